@@ -0,0 +1,61 @@
+package yawf
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Decoder decodes a request body into the value pointed to by its second argument.
+type Decoder func(io.Reader, interface{}) error
+
+// decoders holds the registered Decoders by media type. JSON is supported out of the box.
+var decoders = map[string]Decoder{
+	"application/json": func(r io.Reader, v interface{}) error {
+		return json.NewDecoder(r).Decode(v)
+	},
+}
+
+// RegisterDecoder registers the Decoder used by Bind for request bodies of the given media
+// type, e.g. "application/xml". It replaces any Decoder previously registered for it, and
+// must be called before the server serves requests, e.g. from an init function.
+func RegisterDecoder(mediaType string, fn func(io.Reader, interface{}) error) {
+	decoders[strings.ToLower(mediaType)] = fn
+}
+
+// Bind returns a handler that decodes the request body into a new value of the type of obj
+// and maps it, so that later handlers can inject it. The Decoder is picked by the media
+// type of the request, parameters such as charset being ignored. When no Decoder is
+// registered for it the request is answered with 415 Unsupported Media Type, and when
+// decoding fails with 400 Bad Request, both through the ErrorHandler. Routes binding form
+// bodies with a custom Decoder must be marked with SetRawBody to keep the body unparsed.
+func Bind(obj interface{}) Handler {
+	typ := reflect.TypeOf(obj)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return func(c Context, req *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			respondError(c, http.StatusUnsupportedMediaType, err)
+			return
+		}
+		decode, ok := decoders[mediaType]
+		if !ok {
+			respondError(c, http.StatusUnsupportedMediaType, errors.New("no decoder for media type "+mediaType))
+			return
+		}
+
+		val := reflect.New(typ)
+		if err := decode(req.Body, val.Interface()); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.Map(val.Elem().Interface())
+	}
+}
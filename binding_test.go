@@ -0,0 +1,41 @@
+package yawf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type signup struct {
+	Name string `json:"name"`
+}
+
+func TestBind(t *testing.T) {
+	s := newTestServer()
+	s.Post("/signup", Bind(signup{}), func(form signup) string { return form.Name })
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{"name":"ada"}`, http.StatusOK},
+		// the parameters of the media type are ignored, and its case
+		{"application/json; charset=utf-8", `{"name":"ada"}`, http.StatusOK},
+		{"Application/JSON; charset=UTF-8", `{"name":"ada"}`, http.StatusOK},
+		{"text/csv; charset=utf-8", "name\nada\n", http.StatusUnsupportedMediaType},
+		{"", `{"name":"ada"}`, http.StatusUnsupportedMediaType},
+		{"application/json", `{"name":`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/signup", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		rec := s.ServeTest(req)
+		if rec.Code != test.status || test.status == http.StatusOK && rec.Body.String() != "ada" {
+			t.Errorf("Content-Type %q: got %d %q, want %d", test.contentType, rec.Code, rec.Body.String(), test.status)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package yawf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// BufferedResponseWriter is a ResponseWriter that captures the status, headers and body of a
+// response instead of sending them, so that middleware can inspect or rewrite the whole
+// response (ETags, compression, rolling back a half rendered page) before committing it
+// with Flush. Once flushed, it writes straight through to the underlying ResponseWriter.
+type BufferedResponseWriter struct {
+	rw          ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	beforeFuncs []BeforeFunc
+	committed   bool
+}
+
+// NewBufferedResponseWriter creates a BufferedResponseWriter on top of a ResponseWriter. The
+// headers already set on the latter are copied over.
+func NewBufferedResponseWriter(rw ResponseWriter) *BufferedResponseWriter {
+	return &BufferedResponseWriter{rw: rw, header: rw.Header().Clone()}
+}
+
+func (b *BufferedResponseWriter) Header() http.Header {
+	if b.committed {
+		return b.rw.Header()
+	}
+	return b.header
+}
+
+func (b *BufferedResponseWriter) WriteHeader(s int) {
+	if b.committed {
+		b.rw.WriteHeader(s)
+		return
+	}
+	if b.status == 0 {
+		b.callBefore()
+		b.status = s
+	}
+}
+
+func (b *BufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.committed {
+		return b.rw.Write(p)
+	}
+	if !b.Written() {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.body.Write(p)
+}
+
+// Status returns the buffered status, or the one written to the underlying ResponseWriter
+// once flushed.
+func (b *BufferedResponseWriter) Status() int {
+	if b.committed {
+		return b.rw.Status()
+	}
+	return b.status
+}
+
+func (b *BufferedResponseWriter) Written() bool {
+	return b.Status() != 0
+}
+
+// Size returns the size of the body, buffered or already written.
+func (b *BufferedResponseWriter) Size() int {
+	if b.committed {
+		return b.rw.Size()
+	}
+	return b.body.Len()
+}
+
+func (b *BufferedResponseWriter) Before(before BeforeFunc) {
+	b.beforeFuncs = append(b.beforeFuncs, before)
+}
+
+func (b *BufferedResponseWriter) callBefore() {
+	for i := len(b.beforeFuncs) - 1; i >= 0; i-- {
+		b.beforeFuncs[i](b)
+	}
+}
+
+func (b *BufferedResponseWriter) Hijacked() bool {
+	return false
+}
+
+func (b *BufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return b.rw
+}
+
+func (b *BufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, fmt.Errorf("a buffered ResponseWriter can't be hijacked")
+}
+
+// Push is forwarded to the underlying ResponseWriter, pushes being sent apart from the response.
+func (b *BufferedResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return b.rw.Push(target, opts)
+}
+
+// Body returns the buffered body. It can be modified in place before Flush.
+func (b *BufferedResponseWriter) Body() *bytes.Buffer {
+	return &b.body
+}
+
+// Reset discards the buffered status, body and headers set since the BufferedResponseWriter
+// was created. It has no effect once flushed.
+func (b *BufferedResponseWriter) Reset() {
+	if b.committed {
+		return
+	}
+	b.status = 0
+	b.body.Reset()
+	b.header = b.rw.Header().Clone()
+}
+
+// Flush commits the buffered response to the underlying ResponseWriter, writing a 200 if
+// nothing was buffered at all. Later calls flush the underlying ResponseWriter.
+func (b *BufferedResponseWriter) Flush() {
+	if b.committed {
+		b.rw.Flush()
+		return
+	}
+	b.committed = true
+
+	dst := b.rw.Header()
+	for key := range dst {
+		if _, ok := b.header[key]; !ok {
+			dst.Del(key)
+		}
+	}
+	for key, values := range b.header {
+		dst[key] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.rw.WriteHeader(b.status)
+	b.rw.Write(b.body.Bytes())
+	b.body.Reset()
+}
@@ -0,0 +1,98 @@
+package yawf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AddRouteE is like AddRoute but returns an error rather than panicking when the route is
+// invalid, and when it conflicts with a route already added: both answer to the same method
+// and some path could match both of them. Such routes are otherwise told apart by priority
+// and specificity, see Route.SetPriority. Routes with conditions, e.g. Route.Query, never
+// conflict. The route isn't added if an error is returned.
+func (r *router) AddRouteE(method string, pattern string, h ...Handler) (Route, error) {
+	rt, err := r.buildRoute(method, pattern, h)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route %s %s: %v", method, pattern, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.routes {
+		if rt.conflicts(other) {
+			return nil, fmt.Errorf("route %s %s conflicts with route %s %s", method, rt.pattern, other.method, other.pattern)
+		}
+	}
+	r.appendLocked(rt)
+	return rt, nil
+}
+
+// conflicts returns whether both routes could match a same request.
+func (r *route) conflicts(o *route) bool {
+	if !r.sharesMethod(o) {
+		return false
+	}
+	if len(r.conditions) > 0 || len(o.conditions) > 0 {
+		return false
+	}
+	if r.group.host != nil && o.group.host != nil && r.group.host.String() != o.group.host.String() {
+		return false
+	}
+	return segmentsOverlap(patternSegments(r.pattern), patternSegments(o.pattern))
+}
+
+// sharesMethod returns whether both routes answer to a same method.
+func (r *route) sharesMethod(o *route) bool {
+	if r.method == "*" || o.method == "*" {
+		return true
+	}
+	for _, method := range r.Methods() {
+		if o.MatchMethod(method) == ExactMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// patternSegments splits a pattern into segments, simplified into "*" for a wildcard, "?"
+// for an optional segment, ":" for a segment with parameters or regexp groups, and the
+// literal segment otherwise.
+func patternSegments(pattern string) []string {
+	trimmed := strings.Trim(pattern, "/")
+	if trimmed == "" {
+		return nil
+	}
+	segments := strings.Split(trimmed, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.Contains(segment, "*"):
+			return append(segments[:i], "*")
+		case strings.HasPrefix(segment, "?:"):
+			segments[i] = "?"
+		case strings.ContainsAny(segment, ":(?["):
+			segments[i] = ":"
+		}
+	}
+	return segments
+}
+
+// segmentsOverlap returns whether some path could match both lists of segments.
+func segmentsOverlap(a []string, b []string) bool {
+	if len(a) > 0 && a[0] == "?" {
+		return segmentsOverlap(a[1:], b) || len(b) > 0 && segmentsOverlap(append([]string{":"}, a[1:]...), b)
+	}
+	if len(b) > 0 && b[0] == "?" {
+		return segmentsOverlap(b, a)
+	}
+	if len(a) == 0 || len(b) == 0 {
+		// a wildcard matches no segment at all, e.g. /*path matches /
+		rest := append(a, b...)
+		return len(rest) == 0 || rest[0] == "*"
+	}
+	if a[0] == "*" || b[0] == "*" {
+		return true
+	}
+	if a[0] != ":" && b[0] != ":" && a[0] != b[0] {
+		return false
+	}
+	return segmentsOverlap(a[1:], b[1:])
+}
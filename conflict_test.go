@@ -0,0 +1,155 @@
+package yawf
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSegmentsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"/", "/", true},
+		{"/users", "/users", true},
+		{"/users", "/user", false},
+		{"/users", "/users/", true},
+		{"/users/:id", "/users/new", true},
+		{"/users/:id", "/users/:name", true},
+		{"/users/:id", "/users", false},
+		{"/users/:id", "/", false},
+		{"/:id", "/", false},
+		{"/:a/edit", "/users/:b", true},
+		{"/:a/edit", "/users/:b/x", false},
+		{"/files/(?P<name>[a-z]+)", "/files/:id", true},
+		{"/files/:name.:ext", "/files/readme", true},
+
+		// wildcards match any number of segments, none included
+		{"/files/*path", "/files/a/b/c", true},
+		{"/files/*path", "/files", true},
+		{"/files/*path", "/other/a", false},
+		{"/*path", "/", true},
+		{"/**", "/a/:b/c", true},
+		{"/a/**", "/b/**", false},
+		{"/a/*path", "/:x/b", true},
+
+		// optional segments match with or without their segment
+		{"/a/?:x?", "/a", true},
+		{"/a/?:x?", "/a/b", true},
+		{"/a/?:x?", "/a/b/c", false},
+		{"/a/?:x?", "/b", false},
+		{"/?:x?", "/", true},
+		{"/?:x?", "/a", true},
+		{"/a/?:x?/b", "/a/b", true},
+		{"/a/?:x?/b", "/a/x/b", true},
+		{"/a/?:x?/b", "/a/x/c", false},
+		{"/a/?:x?/?:y?", "/a", true},
+		{"/a/?:x?/?:y?", "/a/b/c", true},
+		{"/a/?:x?/?:y?", "/a/b/c/d", false},
+		{"/a/?:x?", "/a/?:y?/?:z?", true},
+		{"/a/?:x?/c", "/a/?:y?/d", false},
+		// against wildcards, with their segment or without
+		{"/a/?:x?", "/a/*path", true},
+		{"/?:x?", "/*path", true},
+		{"/a/?:x?/b", "/a/*path", true},
+		{"/a/?:x?", "/b/*path", false},
+		{"/?:x?/a", "/**", true},
+	}
+	for _, test := range tests {
+		a, b := patternSegments(test.a), patternSegments(test.b)
+		if overlap := segmentsOverlap(a, b); overlap != test.overlap {
+			t.Errorf("%s and %s: got overlap %t, want %t", test.a, test.b, overlap, test.overlap)
+		}
+		if overlap := segmentsOverlap(b, a); overlap != test.overlap {
+			t.Errorf("%s and %s: got overlap %t, want %t", test.b, test.a, overlap, test.overlap)
+		}
+	}
+}
+
+func TestAddRouteE(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:id", func() string { return "user" })
+
+	tests := []struct {
+		method   string
+		pattern  string
+		conflict bool
+	}{
+		{"GET", "/users/new", true},
+		{"GET", "/users/?:id?", true},
+		{"ANY", "/users/:name", true},
+		{"POST", "/users/:id", false},
+		{"GET", "/users/:id/edit", false},
+		{"GET", "/accounts/:id", false},
+	}
+	for _, test := range tests {
+		method := test.method
+		if method == "ANY" {
+			method = "*"
+		}
+		rt, err := s.AddRouteE(method, test.pattern, func() string { return test.pattern })
+		if test.conflict {
+			if err == nil || !strings.Contains(err.Error(), "conflicts with route GET /users/:id") {
+				t.Errorf("%s %s: got error %v, want a conflict", test.method, test.pattern, err)
+			}
+			if rt != nil {
+				t.Errorf("%s %s: got a route along with the error", test.method, test.pattern)
+			}
+		} else if err != nil {
+			t.Errorf("%s %s: got error %v", test.method, test.pattern, err)
+		}
+	}
+
+	// the conflicting routes weren't added
+	if body := serve(s, "GET", "/users/new").Body.String(); body != "user" {
+		t.Errorf("GET /users/new: got %q, want the first route", body)
+	}
+	if _, err := s.AddRouteE("GET", "/bad/(?P<x>[", func() {}); err == nil {
+		t.Error("an invalid pattern was accepted")
+	}
+}
+
+func TestAddRouteEWithConditions(t *testing.T) {
+	s := newTestServer()
+	s.Get("/search", func() string { return "search" })
+	rt, err := s.AddRouteE("GET", "/search", func() string { return "json" })
+	if err == nil || rt != nil {
+		t.Fatalf("got route %v and error %v, want a conflict", rt, err)
+	}
+
+	// routes told apart by a condition don't conflict
+	s.Get("/items", func() string { return "items" }).Header("Accept", "application/json")
+	if _, err := s.AddRouteE("GET", "/items", func() string { return "others" }); err != nil {
+		t.Errorf("got error %v for a route without the condition", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/items", nil)
+	req.Header.Set("Accept", "application/json")
+	if body := s.ServeTest(req).Body.String(); body != "items" {
+		t.Errorf("got %q, want the route with the condition", body)
+	}
+}
+
+func TestAddRouteEWithHosts(t *testing.T) {
+	s := newTestServer()
+	var errs []error
+	s.Host("api.example.com", func(r Router) {
+		_, err := r.AddRouteE("GET", "/status", func() string { return "api" })
+		errs = append(errs, err)
+	})
+	s.Host("www.example.com", func(r Router) {
+		_, err := r.AddRouteE("GET", "/status", func() string { return "www" })
+		errs = append(errs, err)
+	})
+	// a route for any host overlaps those of every host
+	_, err := s.AddRouteE("GET", "/status", func() string { return "any" })
+	errs = append(errs, err)
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("routes of different hosts conflict: %v", errs[:2])
+	}
+	if errs[2] == nil {
+		t.Error("a route for any host doesn't conflict with the routes of a host")
+	}
+}
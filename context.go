@@ -1,9 +1,19 @@
 package yawf
 
 import (
+	"bufio"
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/codegangsta/inject"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"reflect"
+	"strings"
+	"time"
 )
 
 // Context represents a request context. Services can be mapped on the request level from this interface.
@@ -19,6 +29,124 @@ type Context interface {
 	Stop()
 
 	IsStopped() bool
+
+	// AbortWithStatus writes the status as the response and stops the chain.
+	AbortWithStatus(int)
+	// AbortWithJSON writes the status and the value marshaled to JSON as the response and
+	// stops the chain.
+	AbortWithJSON(int, interface{})
+	// AbortWithError has the ErrorHandler write the response for the status and the error,
+	// and stops the chain.
+	AbortWithError(int, error)
+
+	// Error records an error of the request, for a middleware to report once Next returns.
+	// The errors the framework answers a request with are recorded as well. Nil is ignored.
+	Error(error)
+	// Errors returns the errors recorded for the request, in order.
+	Errors() []error
+
+	// Defer registers a function called once the request is handled and its response fully
+	// written, e.g. to remove temporary files or finalize metrics. The functions registered
+	// with Defer and After are called last registered first, like deferred calls, and a
+	// panic in one of them is logged without preventing the others from running.
+	Defer(func())
+	// After is like Defer for a function given the ResponseWriter, to read the status and
+	// size of the response.
+	After(func(ResponseWriter))
+
+	// Cookie returns the value of the named cookie of the request, or http.ErrNoCookie.
+	Cookie(string) (string, error)
+	// SetCookie adds a Set-Cookie header to the response.
+	SetCookie(*http.Cookie)
+	// SignedCookie returns the value of a cookie set with SetSignedCookie, or ErrInvalidCookie
+	// if its signature isn't valid for any of the CookieKeys.
+	SignedCookie(string) (string, error)
+	// SetSignedCookie is SetCookie for a cookie whose value is signed with the first of the
+	// CookieKeys, so that it can be read by the client but not altered. It returns
+	// ErrNoCookieKeys when there are no keys.
+	SetSignedCookie(*http.Cookie) error
+	// EncryptedCookie is like SignedCookie for a cookie set with SetEncryptedCookie.
+	EncryptedCookie(string) (string, error)
+	// SetEncryptedCookie is like SetSignedCookie for a cookie whose value is encrypted, so
+	// that the client can't read it either.
+	SetEncryptedCookie(*http.Cookie) error
+
+	// Copy returns a detached copy of the context for work that goes on once the request is
+	// handled, e.g. in a goroutine sending an email. It holds a snapshot of the services
+	// and values of the request, including its params and headers, and its request has a
+	// context that isn't canceled with the request's. Its response can't be written.
+	Copy() Context
+
+	// Route returns the Route matched for this request, or nil if no route has been matched yet.
+	Route() Route
+	// RoutePattern returns the pattern of the matched Route, e.g. "/users/:id", or an empty string
+	// when no route matched. Unlike the request path, it is suitable as a metrics label.
+	RoutePattern() string
+
+	// IsSecure returns whether the request was made over TLS, either directly or, when the
+	// peer is a trusted proxy, as reported by the X-Forwarded-Proto header.
+	IsSecure() bool
+	// ClientIP returns the address of the client. When the peer is a trusted proxy, it is the
+	// last address of the Forwarded, X-Forwarded-For or X-Real-IP header, in that order of
+	// preference, that isn't one of the trusted proxies. Otherwise, or when the header is
+	// missing or malformed, it is the address of the peer.
+	ClientIP() string
+
+	// Buffer installs a BufferedResponseWriter as the ResponseWriter of the handlers that run after
+	// the call and returns it. Nothing reaches the client until its Flush method is called.
+	Buffer() *BufferedResponseWriter
+	// ResponseWriter returns the ResponseWriter of the handlers, which forwards Flush, Hijack
+	// and Push to the http.ResponseWriter of the server when it supports them.
+	ResponseWriter() ResponseWriter
+	// Flush sends the response written so far to the client, for streaming responses.
+	Flush()
+	// XML writes the status and the value encoded as XML as the response.
+	XML(int, interface{})
+	// YAML writes the status and the value encoded as YAML as the response.
+	YAML(int, interface{})
+	// Msgpack writes the status and the value encoded as MessagePack as the response.
+	Msgpack(int, interface{})
+	// Stream calls the function with the ResponseWriter and flushes what it wrote, over and
+	// over until it returns false or the client goes away, which is then reported by the
+	// returned value. It suits progressive responses such as long exports or tailed logs.
+	Stream(func(io.Writer) bool) bool
+	// SSEvent sends a Server-Sent Event with the name, which may be empty, and the data, sent
+	// as is when it is a string or a []byte and marshaled to JSON otherwise. The first event
+	// sends the headers of a text/event-stream response. The returned error tells that the
+	// client has gone away.
+	SSEvent(string, interface{}) error
+	// SSEHeartbeat sends a comment on the event stream, which handlers waiting for their next
+	// event should do every few seconds so that proxies don't close the idle connection.
+	SSEHeartbeat() error
+	// Hijack takes over the connection of the request from the server, see http.Hijacker.
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+	// Push initiates an HTTP/2 server push, see http.Pusher. It returns http.ErrNotSupported
+	// when the client or the server don't support it.
+	Push(string, *http.PushOptions) error
+
+	// Ctx returns the context.Context of the request, which is done once the client goes away
+	// or the request times out. The handlers can also have it injected as a context.Context.
+	Ctx() gocontext.Context
+	// WithValue gives the request of the handlers that run after the call a context carrying
+	// the value for the key, as with context.WithValue.
+	WithValue(key, value interface{})
+	// WithTimeout gives the request of the handlers that run after the call a context expiring
+	// after the duration. The returned function releases it and should be called once they
+	// are done, typically by deferring it before calling Next.
+	WithTimeout(time.Duration) gocontext.CancelFunc
+	// IsCanceled returns whether the context of the request has been canceled, which happens
+	// when the client has gone away. Route handlers aren't invoked anymore once it has, but
+	// a long running one should check it, or watch Ctx().Done(), to give up early.
+	IsCanceled() bool
+
+	// SetValue stores a value under the key for the rest of the request, e.g. the current user
+	// for the handlers after an authentication middleware. Unlike Map, values of the same
+	// type can be stored under different keys. (Set and Get are those of the Injector.)
+	SetValue(key string, value interface{})
+	// GetValue returns the value stored under the key and whether there is one.
+	GetValue(key string) (interface{}, bool)
+	// MustGetValue is like GetValue but panics if there is no value for the key.
+	MustGetValue(key string) interface{}
 }
 
 type context struct {
@@ -27,13 +155,34 @@ type context struct {
 	action   Handler
 	rw       ResponseWriter
 	index    int
+
+	formParsed bool
+	formErr    error // why the body couldn't be parsed, if it couldn't
+	values     map[string]interface{}
+	errors     []error
+	deferred   []func()
 }
 
 func NewContext(handlers []Handler, action Handler, res http.ResponseWriter) Context {
-	c := &context{inject.New(), handlers, action, NewResponseWriter(res), -1}
+	c := &context{Injector: newInjector()}
+	c.reset(handlers, action, res)
+	return c
+}
+
+// reset prepares the context for a new request, clearing everything left by the previous one.
+func (c *context) reset(handlers []Handler, action Handler, res http.ResponseWriter) {
+	c.Injector.(*injector).reset()
+	c.handlers, c.action = handlers, action
+	c.rw = NewResponseWriter(res)
+	c.index = -1
+	c.formParsed, c.formErr = false, nil
+	c.errors = nil
+	c.deferred = nil
+	for key := range c.values {
+		delete(c.values, key)
+	}
 	c.MapTo(c, (*Context)(nil))
 	c.MapTo(c.rw, (*http.ResponseWriter)(nil))
-	return c
 }
 
 func (c *context) Next() {
@@ -45,6 +194,201 @@ func (c *context) Written() bool {
 	return c.rw.Written()
 }
 
+func (c *context) Route() Route {
+	rv := c.Get(inject.InterfaceOf((*Route)(nil)))
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface().(Route)
+}
+
+func (c *context) RoutePattern() string {
+	if route := c.Route(); route != nil {
+		return route.Pattern()
+	}
+	return ""
+}
+
+func (c *context) Buffer() *BufferedResponseWriter {
+	b := NewBufferedResponseWriter(c.rw)
+	c.setWriter(b)
+	return b
+}
+
+func (c *context) ResponseWriter() ResponseWriter {
+	return c.rw
+}
+
+func (c *context) Flush() {
+	c.rw.Flush()
+}
+
+func (c *context) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return c.rw.Hijack()
+}
+
+func (c *context) Push(target string, opts *http.PushOptions) error {
+	return c.rw.Push(target, opts)
+}
+
+// setWriter makes the handlers write the response through rw.
+func (c *context) setWriter(rw ResponseWriter) {
+	c.rw = rw
+	c.MapTo(c.rw, (*http.ResponseWriter)(nil))
+}
+
+func (c *context) Ctx() gocontext.Context {
+	if req := c.request(); req != nil {
+		return req.Context()
+	}
+	return gocontext.Background()
+}
+
+func (c *context) IsCanceled() bool {
+	return c.Ctx().Err() == gocontext.Canceled
+}
+
+func (c *context) WithValue(key, value interface{}) {
+	c.setContext(gocontext.WithValue(c.Ctx(), key, value))
+}
+
+func (c *context) WithTimeout(d time.Duration) gocontext.CancelFunc {
+	ctx, cancel := gocontext.WithTimeout(c.Ctx(), d)
+	c.setContext(ctx)
+	return cancel
+}
+
+// setContext maps a copy of the request with the given context, along with the context.
+func (c *context) setContext(ctx gocontext.Context) {
+	if req := c.request(); req != nil {
+		c.Map(req.WithContext(ctx))
+	}
+	c.MapTo(ctx, (*gocontext.Context)(nil))
+}
+
+func (c *context) SetValue(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+func (c *context) GetValue(key string) (interface{}, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+func (c *context) MustGetValue(key string) interface{} {
+	value, ok := c.values[key]
+	if !ok {
+		panic(fmt.Sprintf("no value for the key %q", key))
+	}
+	return value
+}
+
+func (c *context) Error(err error) {
+	if err != nil {
+		c.errors = append(c.errors, err)
+	}
+}
+
+func (c *context) Errors() []error {
+	return c.errors
+}
+
+func (c *context) Defer(f func()) {
+	c.deferred = append(c.deferred, f)
+}
+
+func (c *context) After(f func(ResponseWriter)) {
+	c.Defer(func() { f(c.rw) })
+}
+
+func (c *context) Copy() Context {
+	cp := c.detach(NewResponseWriter(detachedResponseWriter{}))
+	cp.index = 1
+	cp.setContext(gocontext.WithoutCancel(c.Ctx()))
+	return cp
+}
+
+// detach returns a context writing to rw that holds a snapshot of the services and values of
+// the context, rather than looking them up in it, so that it can be used from another goroutine
+// while the request goes on. Mapped maps are cloned, since those of a pooled context are
+// cleared when it is reused.
+func (c *context) detach(rw ResponseWriter) *context {
+	cp := &context{Injector: newInjector(), rw: rw, formParsed: true}
+	inj := c.Injector.(*injector)
+	cp.SetParent(inj.parent)
+	for t, v := range inj.values {
+		if v.Kind() == reflect.Map && !v.IsNil() {
+			clone := reflect.MakeMapWithSize(t, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				clone.SetMapIndex(iter.Key(), iter.Value())
+			}
+			v = clone
+		}
+		cp.Set(t, v)
+	}
+	for key, value := range c.values {
+		cp.SetValue(key, value)
+	}
+	cp.MapTo(cp, (*Context)(nil))
+	cp.MapTo(cp.rw, (*http.ResponseWriter)(nil))
+	return cp
+}
+
+// detachedResponseWriter is the http.ResponseWriter of a copied context.
+type detachedResponseWriter struct{}
+
+func (detachedResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (detachedResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("the response of a copied context can't be written")
+}
+
+func (detachedResponseWriter) WriteHeader(int) {}
+
+// finish calls the functions registered with Defer and After.
+func (c *context) finish() {
+	for i := len(c.deferred) - 1; i >= 0; i-- {
+		c.callDeferred(c.deferred[i])
+	}
+	c.deferred = nil
+}
+
+func (c *context) callDeferred(f func()) {
+	defer func() {
+		if e := recover(); e != nil {
+			if lv := c.Get(reflect.TypeOf((*log.Logger)(nil))); lv.IsValid() {
+				lv.Interface().(*log.Logger).Printf("PANIC in deferred function: %v", e)
+			}
+		}
+	}()
+	f()
+}
+
+func (c *context) IsSecure() bool {
+	req := c.request()
+	if req == nil {
+		return false
+	}
+	if req.TLS != nil {
+		return true
+	}
+	return c.trustsPeer(req) && strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (c *context) request() *http.Request {
+	rv := c.Get(reflect.TypeOf((*http.Request)(nil)))
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface().(*http.Request)
+}
+
 func (c *context) handler() Handler {
 	if c.index < len(c.handlers) {
 		return c.handlers[c.index]
@@ -63,6 +407,28 @@ func (c *context) IsStopped() bool {
 	return !(c.index <= len(c.handlers))
 }
 
+func (c *context) AbortWithStatus(status int) {
+	c.rw.WriteHeader(status)
+	c.Stop()
+}
+
+func (c *context) AbortWithJSON(status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.rw.WriteHeader(status)
+	c.rw.Write(body)
+	c.Stop()
+}
+
+func (c *context) AbortWithError(status int, err error) {
+	respondError(c, status, err)
+	c.Stop()
+}
+
 func (c *context) run() {
 	for !c.IsStopped() {
 		vals, err := c.Invoke(c.handler())
@@ -0,0 +1,70 @@
+package yawf
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestIsSecure(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		tls     bool
+		proto   string
+		secure  bool
+	}{
+		{"plain", nil, false, "", false},
+		{"direct TLS", nil, true, "", true},
+		// httptest requests come from 192.0.2.1
+		{"trusted proxy", []string{"192.0.2.0/24"}, false, "https", true},
+		{"untrusted proxy", []string{"10.0.0.0/8"}, false, "https", false},
+		{"no trusted proxies", nil, false, "https", false},
+		{"trusted proxy over http", []string{"192.0.2.1"}, false, "http", false},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		if err := s.SetTrustedProxies(test.proxies...); err != nil {
+			t.Fatal(err)
+		}
+		s.Get("/", func(c Context) string { return strconv.FormatBool(c.IsSecure()) })
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if test.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		if body := s.ServeTest(req).Body.String(); body != strconv.FormatBool(test.secure) {
+			t.Errorf("%s: got IsSecure %s, want %t", test.name, body, test.secure)
+		}
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	var pattern string
+	s := newTestServer()
+	s.Use(func(c Context) {
+		c.Next()
+		pattern = c.RoutePattern()
+	})
+	s.Get("/users/:id", func() {})
+
+	tests := []struct {
+		path    string
+		pattern string
+	}{
+		{"/users/1", "/users/:id"},
+		{"/users/2", "/users/:id"},
+		{"/missing", ""},
+	}
+	for _, test := range tests {
+		pattern = "unset"
+		serve(s, "GET", test.path)
+		if pattern != test.pattern {
+			t.Errorf("GET %s: got pattern %q, want %q", test.path, pattern, test.pattern)
+		}
+	}
+}
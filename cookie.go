@@ -0,0 +1,166 @@
+package yawf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// CookieKeys is the key ring of the signed and encrypted cookies. The first key signs and
+// encrypts the cookies being set, while all of them are tried on the cookies of a request,
+// so that a new key can be put first and the old one dropped once its cookies have expired.
+// It is mapped on the server by SetCookieKeys.
+type CookieKeys [][]byte
+
+var (
+	// ErrNoCookieKeys is returned for signed and encrypted cookies when no CookieKeys are mapped.
+	ErrNoCookieKeys = errors.New("no cookie keys")
+	// ErrInvalidCookie is returned for a signed or encrypted cookie that none of the keys
+	// verifies, i.e. that has been tampered with or was set with a dropped key.
+	ErrInvalidCookie = errors.New("invalid cookie")
+)
+
+// signCookie returns the signature of the value of the named cookie with the key.
+func signCookie(key []byte, name string, value string) []byte {
+	mac := hmac.New(sha256.New, subkey(key, "sign"))
+	mac.Write([]byte(name + "=" + value))
+	return mac.Sum(nil)
+}
+
+// subkey derives a key for a given use from a key of the ring.
+func subkey(key []byte, use string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(use))
+	return mac.Sum(nil)
+}
+
+// cookieCipher returns the cipher encrypting cookies with the key.
+func cookieCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(subkey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *context) Cookie(name string) (string, error) {
+	req := c.request()
+	if req == nil {
+		return "", http.ErrNoCookie
+	}
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+func (c *context) SetCookie(cookie *http.Cookie) {
+	http.SetCookie(c.rw, cookie)
+}
+
+func (c *context) SignedCookie(name string) (string, error) {
+	keys, err := c.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+	raw, err := c.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	encoded, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range keys {
+		if hmac.Equal(mac, signCookie(key, name, string(value))) {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+func (c *context) SetSignedCookie(cookie *http.Cookie) error {
+	keys, err := c.cookieKeys()
+	if err != nil {
+		return err
+	}
+	signed := *cookie
+	signed.Value = base64.RawURLEncoding.EncodeToString([]byte(cookie.Value)) + "." +
+		base64.RawURLEncoding.EncodeToString(signCookie(keys[0], cookie.Name, cookie.Value))
+	c.SetCookie(&signed)
+	return nil
+}
+
+func (c *context) EncryptedCookie(name string) (string, error) {
+	keys, err := c.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+	raw, err := c.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, key := range keys {
+		aead, err := cookieCipher(key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", ErrInvalidCookie
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		// the name is authenticated so that a value can't be moved to another cookie
+		if value, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+func (c *context) SetEncryptedCookie(cookie *http.Cookie) error {
+	keys, err := c.cookieKeys()
+	if err != nil {
+		return err
+	}
+	aead, err := cookieCipher(keys[0])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	encrypted := *cookie
+	encrypted.Value = base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(cookie.Value), []byte(cookie.Name)))
+	c.SetCookie(&encrypted)
+	return nil
+}
+
+// cookieKeys returns the mapped CookieKeys.
+func (c *context) cookieKeys() (CookieKeys, error) {
+	rv := c.Get(reflect.TypeOf(CookieKeys(nil)))
+	if !rv.IsValid() || rv.Len() == 0 {
+		return nil, ErrNoCookieKeys
+	}
+	return rv.Interface().(CookieKeys), nil
+}
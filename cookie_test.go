@@ -0,0 +1,182 @@
+package yawf
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCookieServer returns a server setting the "signed" and "encrypted" cookies from
+// /set and reading them back from /signed and /encrypted, or answering with the error.
+func newCookieServer(keys ...[]byte) YawfServer {
+	s := newTestServer()
+	if len(keys) > 0 {
+		s.SetCookieKeys(keys...)
+	}
+	s.Get("/set", func(c Context) error {
+		if err := c.SetSignedCookie(&http.Cookie{Name: "signed", Value: "user=42; admin"}); err != nil {
+			return err
+		}
+		return c.SetEncryptedCookie(&http.Cookie{Name: "encrypted", Value: "user=42; admin"})
+	})
+	s.Get("/signed", func(c Context) (int, string) {
+		return cookieResult(c.SignedCookie("signed"))
+	})
+	s.Get("/encrypted", func(c Context) (int, string) {
+		return cookieResult(c.EncryptedCookie("encrypted"))
+	})
+	s.Get("/moved", func(c Context) (int, string) {
+		// the encrypted value presented under another name
+		return cookieResult(c.EncryptedCookie("other"))
+	})
+	return s
+}
+
+// cookieResult answers with the value of a cookie, or a 403 with the error.
+func cookieResult(value string, err error) (int, string) {
+	if err != nil {
+		return http.StatusForbidden, err.Error()
+	}
+	return http.StatusOK, value
+}
+
+// setCookies returns the cookies set by /set.
+func setCookies(t *testing.T, s YawfServer) map[string]*http.Cookie {
+	rec := serve(s, "GET", "/set")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/set: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+// serveCookies runs a GET request with the cookies through the server.
+func serveCookies(s YawfServer, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return s.ServeTest(req)
+}
+
+func TestSignedCookie(t *testing.T) {
+	s := newCookieServer([]byte("key"))
+	cookies := setCookies(t, s)
+	signed := cookies["signed"]
+
+	rec := serveCookies(s, "/signed", signed)
+	if rec.Code != http.StatusOK || rec.Body.String() != "user=42; admin" {
+		t.Errorf("round trip: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	encoded, sig, _ := strings.Cut(signed.Value, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("user=1; admin"))
+	tampered := map[string]string{
+		"value":     forged + "." + sig,
+		"signature": encoded + "." + base64.RawURLEncoding.EncodeToString([]byte("not the signature")),
+		"unsigned":  encoded,
+		"garbage":   "!!!.???",
+	}
+	for name, value := range tampered {
+		rec := serveCookies(s, "/signed", &http.Cookie{Name: "signed", Value: value})
+		if rec.Code != http.StatusForbidden || rec.Body.String() != ErrInvalidCookie.Error() {
+			t.Errorf("%s tampered: got %d %q, want ErrInvalidCookie", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	// the signature covers the name, the value can't be replayed in another cookie
+	other := newCookieServer([]byte("key"))
+	other.Get("/other", func(c Context) (int, string) {
+		return cookieResult(c.SignedCookie("other"))
+	})
+	if rec := serveCookies(other, "/other", &http.Cookie{Name: "other", Value: signed.Value}); rec.Code != http.StatusForbidden {
+		t.Errorf("moved: got %d %q, want ErrInvalidCookie", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEncryptedCookie(t *testing.T) {
+	s := newCookieServer([]byte("key"))
+	cookies := setCookies(t, s)
+	encrypted := cookies["encrypted"]
+	if strings.Contains(encrypted.Value, "user") {
+		t.Errorf("the value shows through: %q", encrypted.Value)
+	}
+
+	rec := serveCookies(s, "/encrypted", encrypted)
+	if rec.Code != http.StatusOK || rec.Body.String() != "user=42; admin" {
+		t.Errorf("round trip: got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// a fresh nonce for every cookie
+	if again := setCookies(t, s)["encrypted"]; again.Value == encrypted.Value {
+		t.Error("the same value was encrypted twice the same way")
+	}
+
+	sealed, _ := base64.RawURLEncoding.DecodeString(encrypted.Value)
+	sealed[len(sealed)-1] ^= 1
+	tampered := map[string]string{
+		"flipped bit": base64.RawURLEncoding.EncodeToString(sealed),
+		"too short":   base64.RawURLEncoding.EncodeToString([]byte("short")),
+		"garbage":     "!!!",
+	}
+	for name, value := range tampered {
+		rec := serveCookies(s, "/encrypted", &http.Cookie{Name: "encrypted", Value: value})
+		if rec.Code != http.StatusForbidden || rec.Body.String() != ErrInvalidCookie.Error() {
+			t.Errorf("%s: got %d %q, want ErrInvalidCookie", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := serveCookies(s, "/moved", &http.Cookie{Name: "other", Value: encrypted.Value}); rec.Code != http.StatusForbidden {
+		t.Errorf("moved: got %d %q, want ErrInvalidCookie", rec.Code, rec.Body.String())
+	}
+
+	wrong := newCookieServer([]byte("another key"))
+	if rec := serveCookies(wrong, "/encrypted", encrypted); rec.Code != http.StatusForbidden || rec.Body.String() != ErrInvalidCookie.Error() {
+		t.Errorf("wrong key: got %d %q, want ErrInvalidCookie", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCookieKeyRotation(t *testing.T) {
+	old := setCookies(t, newCookieServer([]byte("old")))
+
+	rotated := newCookieServer([]byte("new"), []byte("old"))
+	for _, path := range []string{"/signed", "/encrypted"} {
+		rec := serveCookies(rotated, path, old["signed"], old["encrypted"])
+		if rec.Code != http.StatusOK || rec.Body.String() != "user=42; admin" {
+			t.Errorf("%s with the old key: got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	// the cookies are set with the first key only
+	current := setCookies(t, rotated)
+	newOnly := newCookieServer([]byte("new"))
+	oldOnly := newCookieServer([]byte("old"))
+	for _, path := range []string{"/signed", "/encrypted"} {
+		if rec := serveCookies(newOnly, path, current["signed"], current["encrypted"]); rec.Code != http.StatusOK {
+			t.Errorf("%s with the new key: got %d %q", path, rec.Code, rec.Body.String())
+		}
+		if rec := serveCookies(oldOnly, path, current["signed"], current["encrypted"]); rec.Code != http.StatusForbidden {
+			t.Errorf("%s set with the new key accepted by the old one: got %d", path, rec.Code)
+		}
+		// dropping the old key drops its cookies
+		if rec := serveCookies(newOnly, path, old["signed"], old["encrypted"]); rec.Code != http.StatusForbidden {
+			t.Errorf("%s with a dropped key: got %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCookieWithoutKeys(t *testing.T) {
+	s := newCookieServer()
+	if rec := serve(s, "GET", "/set"); rec.Code == http.StatusOK || len(rec.Result().Cookies()) > 0 {
+		t.Errorf("/set without keys: got %d and cookies %v", rec.Code, rec.Result().Cookies())
+	}
+	rec := serveCookies(s, "/signed", &http.Cookie{Name: "signed", Value: "a.b"})
+	if rec.Body.String() != ErrNoCookieKeys.Error() {
+		t.Errorf("got %q, want ErrNoCookieKeys", rec.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package yawf
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+)
+
+// ErrorHandler is a service that Yawf provides that is called whenever the framework itself
+// has to answer with an error status: 404 when no route matches, 405 when routes match the
+// path but not the method, 400 for malformed route parameters or request bodies, 415 for
+// request bodies Bind has no Decoder for, 500 when Recovery catches a panic, the status of
+// a StatusCoder or 500 when a handler returns an error and 504 when the handlers of a route
+// exceed its Timeout. It receives the status and the error that caused it, which may be nil,
+// and is responsible for writing the response.
+type ErrorHandler func(Context, int, error)
+
+func defaultErrorHandler() ErrorHandler {
+	return func(c Context, status int, err error) {
+		c.Invoke(func(res http.ResponseWriter, req *http.Request) {
+			var e *Error
+			if errors.As(err, &e) {
+				body, _ := json.Marshal(e)
+				res.Header().Set("Content-Type", "application/json; charset=utf-8")
+				res.Header().Set("X-Content-Type-Options", "nosniff")
+				res.WriteHeader(status)
+				res.Write(body)
+				return
+			}
+			if status == http.StatusNotFound {
+				http.NotFound(res, req)
+				return
+			}
+			http.Error(res, http.StatusText(status), status)
+		})
+	}
+}
+
+// StatusCoder is implemented by the errors that carry the status of the response they should
+// be answered with, when returned by a handler.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// Error is an error answered with its status code and, by the default ErrorHandler, with its
+// fields as a JSON body, e.g. {"code":404,"message":"user missing"}.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewError returns an Error with the status code and message, which defaults to the text of
+// the status when empty.
+func NewError(code int, message string) *Error {
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	return &Error{Code: code, Message: message}
+}
+
+// BadRequestError returns a 400 Error with the message.
+func BadRequestError(message string) *Error {
+	return NewError(http.StatusBadRequest, message)
+}
+
+// UnauthorizedError returns a 401 Error with the message.
+func UnauthorizedError(message string) *Error {
+	return NewError(http.StatusUnauthorized, message)
+}
+
+// ForbiddenError returns a 403 Error with the message.
+func ForbiddenError(message string) *Error {
+	return NewError(http.StatusForbidden, message)
+}
+
+// NotFoundError returns a 404 Error with the message.
+func NotFoundError(message string) *Error {
+	return NewError(http.StatusNotFound, message)
+}
+
+// ConflictError returns a 409 Error with the message.
+func ConflictError(message string) *Error {
+	return NewError(http.StatusConflict, message)
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) StatusCode() int {
+	return e.Code
+}
+
+// WithDetails sets the details of the error, returning it.
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+// errorStatus returns the status an error returned by a handler is answered with: the one
+// of the first StatusCoder in its chain, or 500.
+func errorStatus(err error) int {
+	var coder StatusCoder
+	if errors.As(err, &coder) {
+		if status := coder.StatusCode(); status >= 400 && status <= 599 {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// respondError answers the request with the given status through the mapped ErrorHandler,
+// recording the error on the context.
+func respondError(c Context, status int, err error) {
+	c.Error(err)
+	handleError := defaultErrorHandler()
+	if ev := c.Get(reflect.TypeOf(ErrorHandler(nil))); ev.IsValid() {
+		handleError = ev.Interface().(ErrorHandler)
+	}
+	handleError(c, status, err)
+}
+
+// notFound is the default NotFound handler.
+func notFound(c Context) {
+	respondError(c, http.StatusNotFound, nil)
+}
+
+// methodNotAllowed is the default MethodNotAllowed handler.
+func methodNotAllowed(c Context) {
+	respondError(c, http.StatusMethodNotAllowed, nil)
+}
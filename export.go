@@ -0,0 +1,52 @@
+package yawf
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo describes a route, as returned by Export.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	Name    string
+	// Handlers are the names of the functions run for the route, those of its groups included.
+	Handlers []string
+}
+
+func (r *router) Export() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := r.routes
+	infos := make([]RouteInfo, len(routes))
+	for i, rt := range routes {
+		infos[i] = RouteInfo{Method: rt.method, Pattern: rt.pattern, Name: rt.name}
+		for _, handler := range rt.chain(rt.Methods()[0]) {
+			infos[i].Handlers = append(infos[i].Handlers, handlerName(handler))
+		}
+	}
+	return infos
+}
+
+func (r *router) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, info := range r.Export() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", info.Method, info.Pattern, info.Name, strings.Join(info.Handlers, ", "))
+	}
+	tw.Flush()
+}
+
+// handlerName returns the name of the function of a handler, e.g. "main.listUsers".
+func handlerName(handler Handler) string {
+	v := reflect.ValueOf(handler)
+	if v.Kind() == reflect.Func {
+		if f := runtime.FuncForPC(v.Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return v.Type().String()
+}
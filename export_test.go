@@ -0,0 +1,48 @@
+package yawf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exportedList() string    { return "list" }
+func exportedShow() string    { return "show" }
+func exportedAdmin(c Context) { c.Next() }
+func exportedPing() string    { return "pong" }
+
+func TestExport(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users", exportedList).SetName("users")
+	s.Group("/admin", func(r Router) {
+		r.Get("/users/:id", exportedShow)
+	}, exportedAdmin)
+	s.Any("/ping", exportedPing)
+
+	want := []RouteInfo{
+		{"GET", "/users", "users", []string{"github.com/farseer810/yawf.exportedList"}},
+		{"GET", "/admin/users/:id", "", []string{"github.com/farseer810/yawf.exportedAdmin", "github.com/farseer810/yawf.exportedShow"}},
+		{"*", "/ping", "", []string{"github.com/farseer810/yawf.exportedPing"}},
+	}
+	if got := s.Export(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users", exportedList).SetName("users")
+	s.Post("/users/:id/roles", exportedShow)
+
+	var buf bytes.Buffer
+	s.Print(&buf)
+	want := strings.Join([]string{
+		"GET   /users            users  github.com/farseer810/yawf.exportedList",
+		"POST  /users/:id/roles         github.com/farseer810/yawf.exportedShow",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,162 @@
+package yawf
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+var formParamsType = reflect.TypeOf(FormParams(nil))
+
+// defaultMultipartMemory is how much of a multipart body is kept in memory when no FormOptions
+// say otherwise, the rest of its files being stored in temporary files.
+const defaultMultipartMemory = 4 << 10
+
+// FormOptions configures the parsing of request bodies into FormParams. It is mapped on the
+// server by SetFormOptions.
+type FormOptions struct {
+	// MaxMemory is how many bytes of a multipart body are kept in memory, the rest of its
+	// files being stored in temporary files. Zero means 4KB.
+	MaxMemory int64
+	// MaxFormSize is the size above which url-encoded bodies are answered with 413 Request
+	// Entity Too Large. Zero means 10MB.
+	MaxFormSize int64
+	// Disabled leaves the request bodies to the handlers, with empty FormParams, as if every
+	// route read its raw body. It suits servers that only take e.g. JSON bodies.
+	Disabled bool
+}
+
+// defaultMaxFormSize is the size above which url-encoded bodies are rejected when no
+// FormOptions say otherwise, as net/http does.
+const defaultMaxFormSize = 10 << 20
+
+// Request bodies are parsed lazily: the FormParams of a request are parsed from its body the
+// first time they are injected. When a route is matched whose handlers inject them, or that is
+// marked with SetParseForm, the body is parsed before the handlers run, so that one that can't
+// be parsed is answered without running any. The other routes leave the body untouched, e.g.
+// for the handlers decoding JSON from it, and req.Form and req.PostForm unset.
+//
+// Since the route is only known once matched, a server-level middleware that injects
+// FormParams has the body parsed even for routes marked with SetRawBody.
+
+// Get returns the service mapped for the type, parsing the form first if FormParams are asked for.
+func (c *context) Get(t reflect.Type) reflect.Value {
+	if t == formParamsType {
+		c.parseForm()
+	}
+	return c.Injector.Get(t)
+}
+
+// Invoke calls the handler with its arguments resolved through the context's Get, so that
+// lazily provided services such as FormParams are available to it.
+func (c *context) Invoke(f interface{}) ([]reflect.Value, error) {
+	t := reflect.TypeOf(f)
+
+	var in = make([]reflect.Value, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		argType := t.In(i)
+		val := c.Get(argType)
+		if !val.IsValid() {
+			return nil, fmt.Errorf("Value not found for type %v", argType)
+		}
+		in[i] = val
+	}
+
+	return reflect.ValueOf(f).Call(in), nil
+}
+
+// parseForm parses the request body into the FormParams, once, unless the matched route reads
+// its raw body or parsing is disabled, in which case empty FormParams are mapped and the body
+// is left untouched. A body that can't be parsed is answered with 400 Bad Request, or 413
+// Request Entity Too Large when it exceeds a limit, through the ErrorHandler, and the
+// handlers are stopped. The temporary files of a multipart body are removed once the request
+// is handled.
+func (c *context) parseForm() {
+	if c.formParsed {
+		return
+	}
+	c.formParsed = true
+
+	req := c.request()
+	if req == nil {
+		return
+	}
+	var options FormOptions
+	if ov := c.Get(reflect.TypeOf(options)); ov.IsValid() {
+		options = ov.Interface().(FormOptions)
+	}
+	if route := c.Route(); options.Disabled || route != nil && route.RawBody() {
+		c.Map(FormParams{})
+		return
+	}
+	maxMemory := options.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = defaultMultipartMemory
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" && req.Body != nil {
+		// a body limited this way isn't limited again by ParseForm, and going over the limit
+		// is told by the error
+		maxFormSize := options.MaxFormSize
+		if maxFormSize == 0 {
+			maxFormSize = defaultMaxFormSize
+		}
+		req.Body = http.MaxBytesReader(c.rw, req.Body, maxFormSize)
+	}
+	err := req.ParseForm()
+	if _, queryErr := url.ParseQuery(req.URL.RawQuery); queryErr != nil {
+		// the error may be the one of the query string, which is left to the handlers as it
+		// is with QueryParams
+		err = nil
+	}
+	if err == nil {
+		err = req.ParseMultipartForm(maxMemory)
+	}
+	if form := req.MultipartForm; form != nil {
+		c.Defer(func() { form.RemoveAll() })
+	}
+	if err != nil && err != http.ErrNotMultipart {
+		c.formErr = err
+		c.Map(FormParams{})
+		respondError(c, formErrorStatus(err), err)
+		c.Stop()
+		return
+	}
+	c.Map(FormParams(req.PostForm))
+}
+
+// injectsFormParams returns whether any of the handlers takes the FormParams.
+func injectsFormParams(handlers []Handler) bool {
+	for _, handler := range handlers {
+		t := reflect.TypeOf(handler)
+		if t == nil || t.Kind() != reflect.Func {
+			continue
+		}
+		for i := 0; i < t.NumIn(); i++ {
+			if t.In(i) == formParamsType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parsesForm parses the form of the request for the handlers of a route, returning false if
+// its body couldn't be parsed, which has been answered then.
+func parsesForm(c Context) bool {
+	c.Get(formParamsType)
+	ctx, ok := c.(*context)
+	return !ok || ctx.formErr == nil
+}
+
+// formErrorStatus returns the status answering a body that couldn't be parsed.
+func formErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || errors.Is(err, multipart.ErrMessageTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
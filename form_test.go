@@ -0,0 +1,161 @@
+package yawf
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// repeatReader endlessly repeats a byte.
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestRawBodyStream(t *testing.T) {
+	const size = 8 << 20
+	s := newTestServer()
+	s.Post("/ingest", func(req *http.Request) (string, error) {
+		n, err := io.Copy(io.Discard, req.Body)
+		return strconv.FormatInt(n, 10), err
+	}).SetRawBody(true)
+
+	// a form body would be parsed, and the body drained, if the route weren't raw
+	body := io.MultiReader(strings.NewReader("data="), io.LimitReader(repeatReader('a'), size-5))
+	req := httptest.NewRequest("POST", "/ingest", body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := s.ServeTest(req); rec.Code != http.StatusOK || rec.Body.String() != strconv.Itoa(size) {
+		t.Errorf("got %d %q, want the %d bytes of the body", rec.Code, rec.Body.String(), size)
+	}
+}
+
+func TestFormErrors(t *testing.T) {
+	s := newTestServer()
+	s.Post("/form", func(FormParams) string { return "handled" })
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        io.Reader
+		status      int
+	}{
+		{"malformed multipart", "multipart/form-data; boundary=xyz", strings.NewReader("--xyz\r\nbroken"), http.StatusBadRequest},
+		{"malformed query", "application/x-www-form-urlencoded", strings.NewReader("a=%zz"), http.StatusBadRequest},
+		{"too large", "application/x-www-form-urlencoded", io.LimitReader(repeatReader('a'), 10<<20+1), http.StatusRequestEntityTooLarge},
+		{"valid", "application/x-www-form-urlencoded", strings.NewReader("a=1"), http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/form", test.body)
+		req.Header.Set("Content-Type", test.contentType)
+		rec := s.ServeTest(req)
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.status)
+		}
+		if test.status != http.StatusOK && rec.Body.String() == "handled" {
+			t.Errorf("%s: the handler ran", test.name)
+		}
+	}
+}
+
+func TestMultipartFilesRemoved(t *testing.T) {
+	var name string
+	s := newTestServer()
+	s.SetFormOptions(FormOptions{MaxMemory: 1})
+	s.Post("/upload", func(req *http.Request) error {
+		f, err := req.MultipartForm.File["file"][0].Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if file, ok := f.(*os.File); ok {
+			name = file.Name()
+		}
+		return nil
+	}).SetParseForm(true)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "data.bin")
+	part.Write(bytes.Repeat([]byte("a"), 1<<10))
+	w.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if rec := s.ServeTest(req); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if name == "" {
+		t.Fatal("the file wasn't stored on disk")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("the temporary file %s is still there", name)
+	}
+}
+
+func TestFormParsedLazily(t *testing.T) {
+	s := newTestServer()
+	s.Post("/body", func(req *http.Request) (string, error) {
+		body, err := io.ReadAll(req.Body)
+		return string(body) + " " + strconv.FormatBool(req.PostForm != nil), err
+	})
+	s.Post("/params", func(f FormParams) string { return f.GetString("a", "") })
+	s.Post("/later", func(c Context) string {
+		// the body is parsed when the FormParams are asked for
+		return c.Get(formParamsType).Interface().(FormParams).GetString("a", "")
+	})
+	s.Post("/eager", func(req *http.Request) string { return req.PostForm.Get("a") }).SetParseForm(true)
+	s.Post("/slow", func(f FormParams) string { return f.GetString("a", "") }).Timeout(time.Second)
+	s.Post("/slower", func(c Context) string {
+		return c.Get(formParamsType).Interface().(FormParams).GetString("a", "")
+	}).Timeout(time.Second)
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"/body", "a=1", http.StatusOK, "a=1 false"},
+		// nobody asked for a form, the body isn't rejected
+		{"/body", "a=%zz", http.StatusOK, "a=%zz false"},
+		{"/params", "a=1", http.StatusOK, "1"},
+		{"/params", "a=%zz", http.StatusBadRequest, ""},
+		{"/later", "a=1", http.StatusOK, "1"},
+		{"/eager", "a=1", http.StatusOK, "1"},
+		{"/eager", "a=%zz", http.StatusBadRequest, ""},
+		{"/slow", "a=1", http.StatusOK, "1"},
+		{"/slower", "a=1", http.StatusOK, "1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := s.ServeTest(req)
+		if rec.Code != test.status || test.want != "" && rec.Body.String() != test.want {
+			t.Errorf("%s %q: got %d %q, want %d %q", test.path, test.body, rec.Code, rec.Body.String(), test.status, test.want)
+		}
+	}
+}
+
+func TestMaxFormSize(t *testing.T) {
+	s := newTestServer()
+	s.SetFormOptions(FormOptions{MaxFormSize: 8})
+	s.Post("/form", func(f FormParams) string { return f.GetString("a", "") })
+
+	for body, status := range map[string]int{"a=1234": http.StatusOK, "a=1234567": http.StatusRequestEntityTooLarge} {
+		req := httptest.NewRequest("POST", "/form", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if rec := s.ServeTest(req); rec.Code != status {
+			t.Errorf("%q: got status %d, want %d", body, rec.Code, status)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package yawf
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headResponseWriter answers a HEAD request with the handlers of a GET route. It discards the
+// body they write, holding the status back until they are done so that the Content-Length can
+// be set to the size the body would have had, unless they set it or flushed the response.
+type headResponseWriter struct {
+	ResponseWriter
+	status int
+	size   int
+}
+
+func (h *headResponseWriter) WriteHeader(s int) {
+	if h.status == 0 {
+		h.status = s
+	}
+}
+
+func (h *headResponseWriter) Write(p []byte) (int, error) {
+	if h.status == 0 {
+		h.WriteHeader(http.StatusOK)
+	}
+	h.size += len(p)
+	return len(p), nil
+}
+
+func (h *headResponseWriter) Status() int {
+	if h.ResponseWriter.Written() {
+		return h.ResponseWriter.Status()
+	}
+	return h.status
+}
+
+func (h *headResponseWriter) Written() bool {
+	return h.Status() != 0 || h.ResponseWriter.Written()
+}
+
+func (h *headResponseWriter) Size() int {
+	return h.size
+}
+
+func (h *headResponseWriter) Flush() {
+	h.commit()
+	h.ResponseWriter.Flush()
+}
+
+func (h *headResponseWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
+
+// commit writes the held back status, along with the Content-Length.
+func (h *headResponseWriter) commit() {
+	if h.status == 0 || h.ResponseWriter.Written() {
+		return
+	}
+	if h.Header().Get("Content-Length") == "" && bodyAllowed(h.status) {
+		h.Header().Set("Content-Length", strconv.Itoa(h.size))
+	}
+	h.ResponseWriter.WriteHeader(h.status)
+}
+
+// bodyAllowed returns whether a response with the status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
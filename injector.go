@@ -0,0 +1,108 @@
+package yawf
+
+import (
+	"fmt"
+	"github.com/codegangsta/inject"
+	"reflect"
+)
+
+// injector is the inject.Injector of the request contexts. It behaves as the one of inject.New
+// but can be reset, so that pooled contexts reuse their map of services.
+type injector struct {
+	values map[reflect.Type]reflect.Value
+	parent inject.Injector
+}
+
+func newInjector() *injector {
+	return &injector{values: make(map[reflect.Type]reflect.Value)}
+}
+
+// reset removes all the services and the parent.
+func (inj *injector) reset() {
+	for t := range inj.values {
+		delete(inj.values, t)
+	}
+	inj.parent = nil
+}
+
+func (inj *injector) Invoke(f interface{}) ([]reflect.Value, error) {
+	t := reflect.TypeOf(f)
+
+	var in = make([]reflect.Value, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		argType := t.In(i)
+		val := inj.Get(argType)
+		if !val.IsValid() {
+			return nil, fmt.Errorf("Value not found for type %v", argType)
+		}
+		in[i] = val
+	}
+
+	return reflect.ValueOf(f).Call(in), nil
+}
+
+func (inj *injector) Apply(val interface{}) error {
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		structField := t.Field(i)
+		if f.CanSet() && (structField.Tag == "inject" || structField.Tag.Get("inject") != "") {
+			ft := f.Type()
+			v := inj.Get(ft)
+			if !v.IsValid() {
+				return fmt.Errorf("Value not found for type %v", ft)
+			}
+			f.Set(v)
+		}
+	}
+	return nil
+}
+
+func (inj *injector) Map(val interface{}) inject.TypeMapper {
+	inj.values[reflect.TypeOf(val)] = reflect.ValueOf(val)
+	return inj
+}
+
+func (inj *injector) MapTo(val interface{}, ifacePtr interface{}) inject.TypeMapper {
+	inj.values[inject.InterfaceOf(ifacePtr)] = reflect.ValueOf(val)
+	return inj
+}
+
+func (inj *injector) Set(typ reflect.Type, val reflect.Value) inject.TypeMapper {
+	inj.values[typ] = val
+	return inj
+}
+
+func (inj *injector) Get(t reflect.Type) reflect.Value {
+	val := inj.values[t]
+	if val.IsValid() {
+		return val
+	}
+
+	// no concrete types found, try to find implementors if t is an interface
+	if t.Kind() == reflect.Interface {
+		for k, v := range inj.values {
+			if k.Implements(t) {
+				val = v
+				break
+			}
+		}
+	}
+
+	if !val.IsValid() && inj.parent != nil {
+		val = inj.parent.Get(t)
+	}
+	return val
+}
+
+func (inj *injector) SetParent(parent inject.Injector) {
+	inj.parent = parent
+}
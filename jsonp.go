@@ -0,0 +1,58 @@
+package yawf
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// jsonpCallbackReg only accepts plain JavaScript identifiers, possibly dotted, as callback names.
+var jsonpCallbackReg = regexp.MustCompile(`^[a-zA-Z_$][0-9a-zA-Z_$]*(?:\.[a-zA-Z_$][0-9a-zA-Z_$]*)*$`)
+
+// jsonpEscaper escapes the line terminators of JavaScript that JSON allows unescaped.
+var jsonpEscaper = strings.NewReplacer("\u2028", `\u2028`, "\u2029", `\u2029`)
+
+// JSONP returns a middleware handler that wraps the JSON response of GET requests carrying
+// the given query parameter into a call to the function it names, served as
+// application/javascript. Callback names that aren't JavaScript identifiers are answered
+// with 400 Bad Request. Requests without the parameter, and responses of other content
+// types, are left untouched.
+func JSONP(callbackParam string) Handler {
+	return func(c Context, req *http.Request) {
+		if req.Method != "GET" {
+			return
+		}
+		callback := req.URL.Query().Get(callbackParam)
+		if callback == "" {
+			return
+		}
+		if !jsonpCallbackReg.MatchString(callback) {
+			respondError(c, http.StatusBadRequest, errors.New("invalid JSONP callback name"))
+			return
+		}
+
+		b := c.Buffer()
+		c.Next()
+
+		if !isJSON(b.Header().Get("Content-Type")) {
+			b.Flush()
+			return
+		}
+		// U+2028 and U+2029 are valid in JSON strings but end the line in older JavaScript
+		body := jsonpEscaper.Replace(strings.TrimSuffix(b.Body().String(), "\n"))
+		b.Body().Reset()
+		// the leading comment defeats content sniffing attacks such as Rosetta Flash
+		b.Body().WriteString("/**/" + callback + "(" + body + ");")
+		b.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		b.Header().Set("X-Content-Type-Options", "nosniff")
+		b.Flush()
+	}
+}
+
+// isJSON returns whether the Content-Type is the one of a JSON body.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
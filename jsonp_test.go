@@ -0,0 +1,51 @@
+package yawf
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type greeting struct {
+	Text string `json:"text"`
+}
+
+func TestJSONPCallbacks(t *testing.T) {
+	s := newTestServer()
+	s.Use(JSONP("callback"))
+	s.Get("/greeting", func() greeting { return greeting{"hi"} })
+
+	valid := map[string]string{
+		"cb":          `/**/cb({"text":"hi"});`,
+		"jQuery.cb_1": `/**/jQuery.cb_1({"text":"hi"});`,
+		"$":           `/**/$({"text":"hi"});`,
+	}
+	for callback, body := range valid {
+		rec := serve(s, "GET", "/greeting?callback="+url.QueryEscape(callback))
+		if rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Errorf("callback %q: got %d %q, want %q", callback, rec.Code, rec.Body.String(), body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/javascript; charset=utf-8" {
+			t.Errorf("callback %q: got Content-Type %q", callback, ct)
+		}
+	}
+
+	malicious := []string{
+		"alert(1);cb",
+		"cb</script><script>alert(1)</script>",
+		"cb//",
+		"cb\n",
+		"cb ",
+		"1cb",
+		"cb.",
+		"cb..x",
+		"cb[0]",
+		"a b",
+		"(function(){})",
+	}
+	for _, callback := range malicious {
+		if rec := serve(s, "GET", "/greeting?callback="+url.QueryEscape(callback)); rec.Code != http.StatusBadRequest {
+			t.Errorf("callback %q: got status %d, want %d", callback, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
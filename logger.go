@@ -0,0 +1,46 @@
+package yawf
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Logger returns a middleware handler that logs the request as it goes in and the response as it goes out.
+// Both entries start with the RequestID of the request, so that they can be paired. The client
+// address is the one given by ClientIP, so that only the TrustedProxies can forward it.
+func Logger() Handler {
+	return func(res http.ResponseWriter, req *http.Request, c Context, id RequestID, log *log.Logger) {
+		start := time.Now()
+
+		log.Printf("[%s] Started %s %q for %s", id, req.Method, req.URL.Path, c.ClientIP())
+
+		rw := res.(ResponseWriter)
+		c.Next()
+
+		if rw.Hijacked() {
+			// the connection or the stream belongs to the handler, there is no status or size to report
+			log.Printf("[%s] Completed hijacked connection in %v\n", id, time.Since(start))
+			return
+		}
+		if pattern := c.RoutePattern(); pattern != "" {
+			log.Printf("[%s] Completed %v %s (%d bytes) in %v for %s\n", id, rw.Status(), http.StatusText(rw.Status()), rw.Size(), time.Since(start), pattern)
+			return
+		}
+		log.Printf("[%s] Completed %v %s (%d bytes) in %v\n", id, rw.Status(), http.StatusText(rw.Status()), rw.Size(), time.Since(start))
+	}
+}
+
+// RequestLogger returns a middleware handler that maps a *log.Logger for the request, writing
+// to the logger of the server with its entries prefixed by the method, path and RequestID of
+// the request, so that the entries of concurrent requests can be told apart and correlated
+// with the logs of other services. The handlers after it just inject the *log.Logger as usual,
+// while those before it, and every handler of a server that doesn't Use it, get the logger of
+// the server. The path is quoted, so that a request can't forge entries with line breaks.
+func RequestLogger() Handler {
+	return func(c Context, req *http.Request, id RequestID, logger *log.Logger) {
+		prefix := logger.Prefix() + req.Method + " " + strconv.Quote(req.URL.Path) + " (" + string(id) + ") "
+		c.Map(log.New(logger.Writer(), prefix, logger.Flags()))
+	}
+}
@@ -0,0 +1,77 @@
+package yawf
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	s := New()
+	s.SetLogger(log.New(&buf, "", 0))
+	s.Use(Logger())
+	s.Get("/", func() string { return "home" })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	s.ServeTest(req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "[req-1] ") {
+			t.Errorf("log line %q doesn't start with the request ID", line)
+		}
+	}
+}
+
+func TestRequestLoggerQuotesPath(t *testing.T) {
+	var buf bytes.Buffer
+	s := New()
+	s.SetLogger(log.New(&buf, "", 0))
+	s.Use(RequestLogger())
+	s.Get("/:name", func(l *log.Logger) { l.Print("hello") })
+
+	req := httptest.NewRequest("GET", "/a%0Aforged%20entry", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	s.ServeTest(req)
+
+	if want := "GET \"/a\\nforged entry\" (req-1) hello\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLoggerClientIP(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		addr    string
+	}{
+		{nil, "192.0.2.1"},
+		{[]string{"192.0.2.1"}, "203.0.113.7"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		s := New()
+		s.SetLogger(log.New(&buf, "", 0))
+		if err := s.SetTrustedProxies(test.proxies...); err != nil {
+			t.Fatal(err)
+		}
+		s.Use(Logger())
+		s.Get("/", func() string { return "home" })
+
+		// httptest requests come from 192.0.2.1
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		s.ServeTest(req)
+
+		if want := " for " + test.addr + "\n"; !strings.Contains(buf.String(), want) {
+			t.Errorf("proxies %v: got %q, want the client logged as %s", test.proxies, buf.String(), test.addr)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package yawf
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mount adds a route for any method and any path under the prefix that delegates to a
+// plain http.Handler, e.g. an http.ServeMux or net/http/pprof. The handler sees the request
+// with the prefix stripped from its path, "/" standing for the prefix itself.
+func (r *router) Mount(prefix string, handler http.Handler) Route {
+	// the prefix to strip depends on the group, see MountRouter
+	build := func(group string) (string, []Handler) {
+		full := strings.TrimRight(joinPattern(group, prefix), "/")
+		strip := regexp.MustCompile(`^` + compilePattern(escapeNonASCII(full)))
+		return full + subtreePattern, []Handler{func(res http.ResponseWriter, req *http.Request) {
+			handler.ServeHTTP(res, stripPrefix(req, strip))
+		}}
+	}
+	current := r.currentGroup()
+	pattern, handlers := build(current.pattern)
+	route := newRoute("*", pattern, handlers)
+	route.build = build
+	route.group = current
+	r.appendRoute(route)
+	return route
+}
+
+// stripPrefix returns a shallow copy of the request whose path has the prefix removed.
+func stripPrefix(req *http.Request, prefix *regexp.Regexp) *http.Request {
+	rawPath := prefix.ReplaceAllString(req.URL.EscapedPath(), "")
+	if !strings.HasPrefix(rawPath, "/") {
+		rawPath = "/" + rawPath
+	}
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		path = rawPath
+	}
+
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.URL = new(url.URL)
+	*r2.URL = *req.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	if r2.URL.EscapedPath() != rawPath {
+		r2.URL.RawPath = rawPath
+	}
+	return r2
+}
+
+// MountRouter moves the groups and routes of sub into a new group under the prefix, the
+// root group of sub merging into the new one.
+func (r *router) MountRouter(prefix string, sub Router, h ...Handler) {
+	from, ok := sub.(*router)
+	if !ok {
+		panic("yawf: MountRouter only accepts routers created by NewRouter")
+	}
+
+	mount := newGroup(r.currentGroup(), prefix, h)
+	r.addGroup(mount)
+	groups := map[*group]*group{}
+	for _, g := range from.groups {
+		if g.parent == nil {
+			mount.handlers = append(mount.handlers, g.handlers...)
+			mount.errorHandler = g.errorHandler
+			groups[g] = mount
+			continue
+		}
+		parent := groups[g.parent]
+		ng := &group{
+			pattern:  joinPattern(mount.pattern, g.pattern),
+			handlers: g.handlers,
+			parent:   parent,
+			depth:    parent.depth + 1,
+			host:     g.host,
+
+			notFounds:    g.notFounds,
+			errorHandler: g.errorHandler,
+		}
+		if ng.host == nil {
+			ng.host = parent.host
+		}
+		ng.compile()
+		groups[g] = ng
+		r.addGroup(ng)
+	}
+
+	var routes []*route
+	for _, rt := range from.routes {
+		moved := rt.clone()
+		moved.group = groups[rt.group]
+		moved.pattern, moved.handlers = rt.rebuilt(moved.group.pattern)
+		moved.paramTypes = parseParamTypes(moved.pattern)
+		moved.compile()
+		routes = append(routes, moved)
+	}
+	if _, err := r.appendRoutes(routes); err != nil {
+		panic(err.Error())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if from.notFounds != nil || len(from.prefixNotFounds) > 0 {
+		if r.prefixNotFounds == nil {
+			r.prefixNotFounds = make(map[string][]Handler)
+		}
+		if from.notFounds != nil {
+			r.prefixNotFounds[mount.pattern] = from.notFounds
+		}
+		for p, handlers := range from.prefixNotFounds {
+			r.prefixNotFounds[joinPattern(mount.pattern, p)] = handlers
+		}
+	}
+}
+
+// rebuilt returns the pattern and handlers of the route under the group prefix, rather than
+// the one it was added under.
+func (r *route) rebuilt(prefix string) (string, []Handler) {
+	if r.build != nil {
+		return r.build(prefix)
+	}
+	if r.pattern == "/" && prefix != "" {
+		return prefix, r.handlers
+	}
+	return joinPattern(prefix, r.raw), r.handlers
+}
+
+// clone returns a copy of the route that doesn't share its settings with it, added to no
+// router yet.
+func (r *route) clone() *route {
+	cp := *r
+	cp.router, cp.seq = nil, 0
+	cp.before = append([]Handler(nil), r.before...)
+	cp.after = append([]Handler(nil), r.after...)
+	cp.conditions = append([]func(*http.Request) bool(nil), r.conditions...)
+	if r.meta != nil {
+		cp.meta = make(map[string]interface{}, len(r.meta))
+		for key, val := range r.meta {
+			cp.meta[key] = val
+		}
+	}
+	if r.constraints != nil {
+		cp.constraints = make(map[string]*regexp.Regexp, len(r.constraints))
+		for param, constraint := range r.constraints {
+			cp.constraints[param] = constraint
+		}
+	}
+	return &cp
+}
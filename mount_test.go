@@ -0,0 +1,39 @@
+package yawf
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMountRouter(t *testing.T) {
+	sub := NewRouter()
+	users := sub.Get("/users/:id", func(p PathParams) string { return "user " + p["id"] })
+	users.Where("id", `\d+`)
+	sub.Mount("/files", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("file " + req.URL.Path))
+	}))
+	sub.Fallback(func() string { return "fallback" })
+
+	s := newTestServer()
+	s.MountRouter("/api", sub)
+	// the mounted routes are copies, changing the ones of sub leaves them alone
+	users.Where("id", `[a-z]+`)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/api/users/1", http.StatusOK, "user 1"},
+		{"/api/files/a/b.txt", http.StatusOK, "file /a/b.txt"},
+		{"/api/files", http.StatusOK, "file /"},
+		{"/api/other", http.StatusOK, "fallback"},
+		{"/other", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		rec := serve(s, "GET", test.path)
+		if rec.Code != test.status || test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, rec.Code, rec.Body.String(), test.status, test.body)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package yawf
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MessagePack goes through JSON as YAML does, so that fields are named and omitted as with
+// encoding/json. Numbers are written as the smallest integer that holds them, or as float64,
+// and byte slices as binaries rather than the base64 strings encoding/json makes of them.
+
+func init() {
+	encoders["application/msgpack"] = encodeMsgpack
+	encoders["application/x-msgpack"] = encodeMsgpack
+}
+
+// Msgpack wraps a value returned by a handler to have it encoded as MessagePack.
+func Msgpack(v interface{}) Encoded {
+	return Encoded{"application/msgpack", v}
+}
+
+func (c *context) Msgpack(status int, v interface{}) {
+	c.render(status, Msgpack(v))
+}
+
+// encodeMsgpack is the Encoder of application/msgpack.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	doc, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeMsgpack(&buf, withBinaries(doc, reflect.ValueOf(v)))
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// writeMsgpack writes a node decoded by decodeOrdered.
+func writeMsgpack(buf *bytes.Buffer, node interface{}) {
+	switch v := node.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		writeMsgpackNumber(buf, v)
+	case string:
+		writeMsgpackHeader(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []byte:
+		writeMsgpackHeader(buf, len(v), 0, 0, 0xc4, 0xc5, 0xc6)
+		buf.Write(v)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			writeMsgpack(buf, item)
+		}
+	case []jsonField:
+		writeMsgpackHeader(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, field := range v {
+			writeMsgpack(buf, field.key)
+			writeMsgpack(buf, field.value)
+		}
+	}
+}
+
+// writeMsgpackHeader writes the type and length of a string, binary, array or map: in the
+// fixed format when the length is below fixMax, else in the 8 (if any), 16 or 32 bits one.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8 byte, code16 byte, code32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{code8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(code32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+// writeMsgpackNumber writes a number as the smallest integer format that holds it, or as a
+// float64 if it isn't an integer.
+func writeMsgpackNumber(buf *bytes.Buffer, n json.Number) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		switch {
+		case i >= 0 && i <= math.MaxInt8, i < 0 && i >= -32:
+			buf.WriteByte(byte(i))
+		case i >= 0:
+			writeMsgpackUint(buf, uint64(i))
+		case i >= math.MinInt8:
+			buf.Write([]byte{0xd0, byte(i)})
+		case i >= math.MinInt16:
+			buf.WriteByte(0xd1)
+			buf.Write(binary.BigEndian.AppendUint16(nil, uint16(i)))
+		case i >= math.MinInt32:
+			buf.WriteByte(0xd2)
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+		default:
+			buf.WriteByte(0xd3)
+			buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+		}
+		return
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		writeMsgpackUint(buf, u)
+		return
+	}
+	f, _ := n.Float64()
+	buf.WriteByte(0xcb)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+// writeMsgpackUint writes a non negative integer above the positive fixint range.
+func writeMsgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(u)))
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(u)))
+	default:
+		buf.WriteByte(0xcf)
+		buf.Write(binary.BigEndian.AppendUint64(nil, u))
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// withBinaries replaces the strings of a node decoded by decodeOrdered that encoding/json made
+// of byte slices of the value it encoded with the byte slices themselves. Values encoding
+// themselves, through json.Marshaler or encoding.TextMarshaler, are left as they encoded.
+func withBinaries(node interface{}, v reflect.Value) interface{} {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !marshalsItself(v.Type()) {
+		v = v.Elem()
+	}
+	if !v.IsValid() || marshalsItself(v.Type()) {
+		return node
+	}
+	switch n := node.(type) {
+	case string:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes()
+		}
+	case []interface{}:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Len() != len(n) {
+			return node
+		}
+		items := make([]interface{}, len(n))
+		for i, item := range n {
+			items[i] = withBinaries(item, v.Index(i))
+		}
+		return items
+	case []jsonField:
+		var fieldOf func(string) reflect.Value
+		switch v.Kind() {
+		case reflect.Struct:
+			fieldOf = func(key string) reflect.Value { return jsonStructField(v, key) }
+		case reflect.Map:
+			values := make(map[string]reflect.Value, v.Len())
+			for iter := v.MapRange(); iter.Next(); {
+				if key, ok := jsonMapKey(iter.Key()); ok {
+					values[key] = iter.Value()
+				}
+			}
+			fieldOf = func(key string) reflect.Value { return values[key] }
+		default:
+			return node
+		}
+		fields := make([]jsonField, len(n))
+		for i, field := range n {
+			fields[i] = jsonField{field.key, withBinaries(field.value, fieldOf(field.key))}
+		}
+		return fields
+	}
+	return node
+}
+
+// marshalsItself returns whether encoding/json has the values of the type, or pointers to
+// them, encode themselves.
+func marshalsItself(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+		pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType)
+}
+
+// jsonStructField returns the field of a struct encoding/json encodes under the key, looking
+// into embedded structs like it does: the shallowest field wins, a tagged one among those at
+// the same depth.
+func jsonStructField(v reflect.Value, key string) reflect.Value {
+	var found reflect.Value
+	foundDepth, foundTagged := -1, false
+	var walk func(v reflect.Value, depth int)
+	walk = func(v reflect.Value, depth int) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				fv := v.Field(i)
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				if fv.Kind() == reflect.Struct {
+					walk(fv, depth+1)
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = f.Name
+			}
+			if name == key && (foundDepth < 0 || depth < foundDepth || depth == foundDepth && tagged && !foundTagged) {
+				found, foundDepth, foundTagged = v.Field(i), depth, tagged
+			}
+		}
+	}
+	walk(v, 0)
+	return found
+}
+
+// jsonMapKey returns the key encoding/json encodes the key of a map entry as.
+func jsonMapKey(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	if k.Type().Implements(textMarshalerType) {
+		if !k.CanInterface() {
+			return "", false
+		}
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", true
+		}
+		text, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err == nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
@@ -0,0 +1,162 @@
+package yawf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fixstr returns the hex encoding of a short string as a MessagePack fixstr.
+func fixstr(s string) string {
+	return hex.EncodeToString(append([]byte{0xa0 | byte(len(s))}, s...))
+}
+
+func TestMsgpackNumbers(t *testing.T) {
+	tests := []struct {
+		n   string
+		hex string
+	}{
+		{"0", "00"},
+		{"127", "7f"},
+		{"128", "cc80"},
+		{"255", "ccff"},
+		{"256", "cd0100"},
+		{"65535", "cdffff"},
+		{"65536", "ce00010000"},
+		{"4294967295", "ceffffffff"},
+		{"4294967296", "cf0000000100000000"},
+		{"9223372036854775807", "cf7fffffffffffffff"},
+		{"18446744073709551615", "cfffffffffffffffff"},
+		{"-1", "ff"},
+		{"-32", "e0"},
+		{"-33", "d0df"},
+		{"-128", "d080"},
+		{"-129", "d1ff7f"},
+		{"-32768", "d18000"},
+		{"-32769", "d2ffff7fff"},
+		{"-2147483648", "d280000000"},
+		{"-2147483649", "d3ffffffff7fffffff"},
+		{"-9223372036854775808", "d38000000000000000"},
+		{"1.5", "cb3ff8000000000000"},
+		{"18446744073709551616", "cb43f0000000000000"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeMsgpackNumber(&buf, json.Number(test.n))
+		if got := hex.EncodeToString(buf.Bytes()); got != test.hex {
+			t.Errorf("%s: got %s, want %s", test.n, got, test.hex)
+		}
+	}
+}
+
+func TestMsgpackLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		node   interface{}
+		header string
+	}{
+		{"fixstr", strings.Repeat("a", 31), "bf"},
+		{"str 8", strings.Repeat("a", 32), "d920"},
+		{"str 8 max", strings.Repeat("a", 255), "d9ff"},
+		{"str 16", strings.Repeat("a", 256), "da0100"},
+		{"str 32", strings.Repeat("a", 65536), "db00010000"},
+		{"empty bin", []byte{}, "c400"},
+		{"bin 8", make([]byte, 255), "c4ff"},
+		{"bin 16", make([]byte, 256), "c50100"},
+		{"bin 32", make([]byte, 65536), "c600010000"},
+		{"fixarray", make([]interface{}, 15), "9f"},
+		{"array 16", make([]interface{}, 16), "dc0010"},
+		{"array 32", make([]interface{}, 65536), "dd00010000"},
+		{"fixmap", make([]jsonField, 15), "8f"},
+		{"map 16", make([]jsonField, 16), "de0010"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeMsgpack(&buf, test.node)
+		if got := hex.EncodeToString(buf.Bytes()); !strings.HasPrefix(got, test.header) {
+			t.Errorf("%s: got header %.12s, want %s", test.name, got, test.header)
+		}
+	}
+}
+
+// blob has byte slices encoded as binaries, except for the one encoding itself.
+type blob struct {
+	Name string          `json:"name"`
+	Data []byte          `json:"data"`
+	Skip []byte          `json:"-"`
+	Raw  json.RawMessage `json:"raw"`
+}
+
+type blobInner struct {
+	Data []byte
+}
+
+type blobOuter struct {
+	blobInner
+	Items [][]byte
+	ByID  map[int][]byte
+	Ptr   *[]byte
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	data := []byte{4}
+	tests := []struct {
+		name string
+		v    interface{}
+		hex  string
+	}{
+		{
+			"tagged fields",
+			blob{"a", []byte{1, 2}, []byte{9}, json.RawMessage(`"AQI="`)},
+			"83" + fixstr("name") + fixstr("a") + fixstr("data") + "c4020102" + fixstr("raw") + fixstr("AQI="),
+		},
+		{
+			"embedded, nested and nil",
+			blobOuter{blobInner{[]byte{1}}, [][]byte{{2}, {}}, map[int][]byte{7: {3}}, nil},
+			"84" + fixstr("Data") + "c40101" + fixstr("Items") + "92c40102c400" +
+				fixstr("ByID") + "81" + fixstr("7") + "c40103" + fixstr("Ptr") + "c0",
+		},
+		{
+			"pointers and interfaces",
+			map[string]interface{}{"p": &data, "nil": []byte(nil), "s": "AQ=="},
+			"83" + fixstr("nil") + "c0" + fixstr("p") + "c40104" + fixstr("s") + fixstr("AQ=="),
+		},
+		{"byte array", [2]byte{1, 2}, "920102"},
+		{"top level", []byte("hi"), "c4026869"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := encodeMsgpack(&buf, test.v); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(buf.Bytes()); got != test.hex {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.hex)
+		}
+	}
+}
+
+func TestMsgpackResponse(t *testing.T) {
+	s := newTestServer()
+	s.Get("/blob", func() blob { return blob{Name: "a", Data: []byte{1}} })
+	s.Get("/wrapped", func() Encoded { return Msgpack([]byte{1}) })
+
+	req := httptest.NewRequest("GET", "/blob", nil)
+	req.Header.Set("Accept", "application/x-msgpack")
+	rec := s.ServeTest(req)
+	want := "83" + fixstr("name") + fixstr("a") + fixstr("data") + "c40101" + fixstr("raw") + "c0"
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-msgpack" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	if got := hex.EncodeToString(rec.Body.Bytes()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	rec = serve(s, "GET", "/wrapped")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/msgpack" || hex.EncodeToString(rec.Body.Bytes()) != "c40101" {
+		t.Errorf("got %q as %q", rec.Body.Bytes(), ct)
+	}
+}
@@ -0,0 +1,74 @@
+package yawf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// A route parameter may declare a type with the `:name:type` syntax, e.g. `/users/:id:int`.
+// The captured value is matched as any other parameter, then checked against the type: when
+// it doesn't conform and no other route matches the request, the router responds with
+// 400 Bad Request without invoking any handler. The name of the parameter in PathParams
+// doesn't include the type.
+//
+// The built-in types are string, int, uint, float, bool and uuid; more can be added with
+// RegisterParamType.
+var paramTypes = map[string]func(string) bool{
+	"string": func(string) bool { return true },
+	"int": func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"uint": func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	},
+	"float": func(s string) bool {
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	},
+	"bool": func(s string) bool {
+		_, err := strconv.ParseBool(s)
+		return err == nil
+	},
+	"uuid": uuidReg.MatchString,
+}
+
+var uuidReg = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// RegisterParamType adds a parameter type usable with the `:name:type` syntax. It must be
+// called before the routes using it are registered.
+func RegisterParamType(name string, valid func(string) bool) {
+	paramTypes[name] = valid
+}
+
+// splitParam splits a `:name:type` placeholder into its name and type.
+func splitParam(placeholder string) (string, string) {
+	name := placeholder[1:]
+	for i := 0; i < len(name); i++ {
+		if name[i] == ':' {
+			return name[:i], name[i+1:]
+		}
+	}
+	return name, ""
+}
+
+// parseParamTypes returns the declared types of the parameters of a pattern.
+func parseParamTypes(pattern string) map[string]string {
+	var types map[string]string
+	for _, m := range routeReg1.FindAllString(pattern, -1) {
+		name, typ := splitParam(m)
+		if typ == "" {
+			continue
+		}
+		if _, ok := paramTypes[typ]; !ok {
+			panic(fmt.Sprintf("unknown type %q for route parameter %q", typ, name))
+		}
+		if types == nil {
+			types = make(map[string]string)
+		}
+		types[name] = typ
+	}
+	return types
+}
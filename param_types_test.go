@@ -0,0 +1,32 @@
+package yawf
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParamTypes(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:id:int", func(p PathParams) string { return "user " + p["id"] })
+	s.Get("/orders/:id:uuid", func(p PathParams) string { return "order " + p["id"] })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/users/42", http.StatusOK, "user 42"},
+		{"/users/-7", http.StatusOK, "user -7"},
+		{"/users/abc", http.StatusBadRequest, ""},
+		{"/users/4.2", http.StatusBadRequest, ""},
+		{"/orders/123e4567-e89b-12d3-a456-426614174000", http.StatusOK, "order 123e4567-e89b-12d3-a456-426614174000"},
+		{"/orders/123e4567", http.StatusBadRequest, ""},
+		{"/orders/42", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rec := serve(s, "GET", test.path)
+		if rec.Code != test.status || test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, rec.Code, rec.Body.String(), test.status, test.body)
+		}
+	}
+}
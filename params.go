@@ -0,0 +1,228 @@
+package yawf
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The typed accessors of PathParams return an error naming the parameter when it is missing
+// or doesn't parse, which handlers can pass on to the ErrorHandler with a 400. Parameters
+// declared with a type, e.g. `/users/:id:int`, are known to parse.
+
+// param returns the value of the named parameter, or an error if there is none.
+func (p PathParams) param(name string) (string, error) {
+	value, ok := p[name]
+	if !ok {
+		return "", fmt.Errorf("missing path parameter %q", name)
+	}
+	return value, nil
+}
+
+// paramError describes a parameter that failed to parse.
+func paramError(name string, value string, typ string) error {
+	return fmt.Errorf("path parameter %q: %q is not a valid %s", name, value, typ)
+}
+
+// Int returns the named parameter as an int.
+func (p PathParams) Int(name string) (int, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, paramError(name, value, "int")
+	}
+	return i, nil
+}
+
+// MustInt returns the named parameter as an int, or def if it is missing or isn't one.
+func (p PathParams) MustInt(name string, def int) int {
+	i, err := p.Int(name)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// Int64 returns the named parameter as an int64.
+func (p PathParams) Int64(name string) (int64, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, paramError(name, value, "int64")
+	}
+	return i, nil
+}
+
+// Uint returns the named parameter as a uint.
+func (p PathParams) Uint(name string) (uint, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return 0, err
+	}
+	u, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return 0, paramError(name, value, "uint")
+	}
+	return uint(u), nil
+}
+
+// Bool returns the named parameter as a bool, accepting the values of strconv.ParseBool.
+func (p PathParams) Bool(name string) (bool, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, paramError(name, value, "bool")
+	}
+	return b, nil
+}
+
+// Float returns the named parameter as a float64.
+func (p PathParams) Float(name string) (float64, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, paramError(name, value, "float")
+	}
+	return f, nil
+}
+
+// UUID returns the named parameter if it is a UUID in its canonical textual form, lower cased.
+func (p PathParams) UUID(name string) (string, error) {
+	value, err := p.param(name)
+	if err != nil {
+		return "", err
+	}
+	if !uuidReg.MatchString(value) {
+		return "", paramError(name, value, "uuid")
+	}
+	return strings.ToLower(value), nil
+}
+
+// The getters of QueryParams and FormParams return the default they are given when the
+// parameter is missing, empty or doesn't parse, so that e.g. GetInt("page", 1) is all a
+// paginated handler needs. Only the first value of a repeated parameter is considered,
+// except by GetStrings.
+
+func (q QueryParams) GetString(name string, def string) string {
+	return getString(url.Values(q), name, def)
+}
+
+func (q QueryParams) GetInt(name string, def int) int {
+	return getInt(url.Values(q), name, def)
+}
+
+func (q QueryParams) GetFloat(name string, def float64) float64 {
+	return getFloat(url.Values(q), name, def)
+}
+
+func (q QueryParams) GetBool(name string, def bool) bool {
+	return getBool(url.Values(q), name, def)
+}
+
+func (q QueryParams) GetTime(name string, layout string, def time.Time) time.Time {
+	return getTime(url.Values(q), name, layout, def)
+}
+
+func (q QueryParams) GetStrings(name string) []string {
+	return getStrings(url.Values(q), name)
+}
+
+func (f FormParams) GetString(name string, def string) string {
+	return getString(url.Values(f), name, def)
+}
+
+func (f FormParams) GetInt(name string, def int) int {
+	return getInt(url.Values(f), name, def)
+}
+
+func (f FormParams) GetFloat(name string, def float64) float64 {
+	return getFloat(url.Values(f), name, def)
+}
+
+func (f FormParams) GetBool(name string, def bool) bool {
+	return getBool(url.Values(f), name, def)
+}
+
+func (f FormParams) GetTime(name string, layout string, def time.Time) time.Time {
+	return getTime(url.Values(f), name, layout, def)
+}
+
+func (f FormParams) GetStrings(name string) []string {
+	return getStrings(url.Values(f), name)
+}
+
+func getString(values url.Values, name string, def string) string {
+	if value := values.Get(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func getInt(values url.Values, name string, def int) int {
+	i, err := strconv.Atoi(values.Get(name))
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+func getFloat(values url.Values, name string, def float64) float64 {
+	f, err := strconv.ParseFloat(values.Get(name), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// getBool accepts the values of strconv.ParseBool, as well as "on", as sent for a checked
+// checkbox, "off", "yes" and "no".
+func getBool(values url.Values, name string, def bool) bool {
+	switch value := strings.ToLower(values.Get(name)); value {
+	case "on", "yes":
+		return true
+	case "off", "no":
+		return false
+	default:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return def
+		}
+		return b
+	}
+}
+
+func getTime(values url.Values, name string, layout string, def time.Time) time.Time {
+	t, err := time.Parse(layout, values.Get(name))
+	if err != nil {
+		return def
+	}
+	return t
+}
+
+// getStrings returns all the values of the parameter, with comma separated lists split, so
+// that "?tag=a,b&tag=c" gives a, b and c. Empty values are left out.
+func getStrings(values url.Values, name string) []string {
+	var list []string
+	for _, value := range values[name] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
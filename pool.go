@@ -0,0 +1,35 @@
+package yawf
+
+import "net/http"
+
+// pooledContext is a context kept in the pool of the server along with the Headers and
+// QueryParams of its request, which are cleared rather than allocated again.
+type pooledContext struct {
+	*context
+	headers Headers
+	query   QueryParams
+}
+
+// acquireContext is CreateContext for a context taken from the pool.
+func (s *yawf) acquireContext(res http.ResponseWriter, req *http.Request) *pooledContext {
+	c, ok := s.contexts.Get().(*pooledContext)
+	if !ok {
+		c = &pooledContext{&context{Injector: newInjector()}, make(Headers), make(QueryParams)}
+	}
+	c.reset(s.handlers, s.action, res)
+	s.mapRequest(c.context, req, c.headers, c.query)
+	return c
+}
+
+// releaseContext puts the context back into the pool once its request is handled.
+func (s *yawf) releaseContext(c *pooledContext) {
+	// drop the services of the request until the context is reused
+	c.Injector.(*injector).reset()
+	for key := range c.headers {
+		delete(c.headers, key)
+	}
+	for key := range c.query {
+		delete(c.query, key)
+	}
+	s.contexts.Put(c)
+}
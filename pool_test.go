@@ -0,0 +1,92 @@
+package yawf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// leftover is a service mapped by a request, which the next one mustn't see.
+type leftover string
+
+func TestPooledContextsDontLeak(t *testing.T) {
+	s := newTestServer()
+	s.SetContextPooling(true)
+
+	var deferred int
+	contexts := map[string]bool{}
+	reused := false
+	s.Use(func(c Context) {
+		// the context the server hands to its middlewares is the pooled one
+		id := fmt.Sprintf("%p", c)
+		reused = reused || contexts[id]
+		contexts[id] = true
+	})
+	s.Post("/first", func(c Context, h Headers, q QueryParams, f FormParams) string {
+		c.Map(leftover("first"))
+		c.SetValue("request", "first")
+		c.WithValue("request", "first")
+		c.Error(errors.New("first failed"))
+		c.Defer(func() { deferred++ })
+		h["X-Added"] = "first"
+		q["added"] = []string{"first"}
+		return h["X-First"] + q.GetString("first", "") + f.GetString("first", "")
+	})
+	s.Post("/second", func(c Context, h Headers, q QueryParams, f FormParams, req *http.Request) string {
+		var leaks []string
+		if c.Get(reflect.TypeOf(leftover(""))).IsValid() {
+			leaks = append(leaks, "service")
+		}
+		if _, ok := c.GetValue("request"); ok {
+			leaks = append(leaks, "value")
+		}
+		if c.Ctx().Value("request") != nil {
+			leaks = append(leaks, "context value")
+		}
+		if len(c.Errors()) > 0 {
+			leaks = append(leaks, "errors")
+		}
+		if _, ok := h["X-First"]; ok || h["X-Added"] != "" || h["X-Second"] != "2" {
+			leaks = append(leaks, fmt.Sprintf("headers %v", h))
+		}
+		if len(q) != 1 || q.GetString("second", "") != "2" {
+			leaks = append(leaks, fmt.Sprintf("query %v", q))
+		}
+		if len(f) != 1 || f.GetString("second", "") != "2" {
+			leaks = append(leaks, fmt.Sprintf("form %v", f))
+		}
+		if c.Route() == nil || c.Route().Pattern() != "/second" {
+			leaks = append(leaks, "route")
+		}
+		return strings.Join(leaks, ", ")
+	})
+
+	// pools may drop their items, e.g. under the race detector, so that a context is only
+	// reused by some of the requests
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest("POST", "/first?first=1", strings.NewReader("first=1"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-First", "1")
+		if body := s.ServeTest(req).Body.String(); body != "111" {
+			t.Fatalf("first request: got %q", body)
+		}
+
+		req = httptest.NewRequest("POST", "/second?second=2", strings.NewReader("second=2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Second", "2")
+		rec := s.ServeTest(req)
+		if rec.Code != http.StatusOK || rec.Body.String() != "" {
+			t.Fatalf("second request: got %d, leaked %s", rec.Code, rec.Body.String())
+		}
+	}
+	if deferred != 50 {
+		t.Errorf("the deferred functions ran %d times, want 50", deferred)
+	}
+	if !reused {
+		t.Error("no context was reused")
+	}
+}
@@ -0,0 +1,50 @@
+// Package protobuf has the proto.Messages returned by yawf handlers encoded as protobuf, or
+// with protojson when the request prefers JSON, whatever the RenderOptions. It registers its
+// Encoders when imported, usually for its side effects only:
+//
+//	import _ "github.com/farseer810/yawf/protobuf"
+package protobuf
+
+import (
+	"fmt"
+	"github.com/farseer810/yawf"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"reflect"
+)
+
+// MediaType is the media type of protobuf bodies.
+const MediaType = "application/x-protobuf"
+
+func init() {
+	yawf.RegisterEncoder(MediaType, encode)
+	yawf.RegisterTypeEncoders(reflect.TypeOf((*proto.Message)(nil)).Elem(), MediaType, map[string]yawf.Encoder{
+		MediaType:          encode,
+		"application/json": encodeJSON,
+	})
+}
+
+// encode is the Encoder of application/x-protobuf, which only encodes proto.Messages.
+func encode(w io.Writer, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// encodeJSON encodes proto.Messages as JSON with protojson.
+func encodeJSON(w io.Writer, v interface{}) error {
+	b, err := protojson.Marshal(v.(proto.Message))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,68 @@
+package protobuf
+
+import (
+	"github.com/farseer810/yawf"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	msg := wrapperspb.String("hello")
+	pb, _ := proto.Marshal(msg)
+	js, _ := protojson.Marshal(msg)
+
+	s := yawf.New()
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.SetRenderOptions(yawf.RenderOptions{DefaultMediaType: "application/yaml"})
+	s.Get("/msg", func() *wrapperspb.StringValue { return msg })
+	s.Get("/created", func() (int, *wrapperspb.StringValue) { return 201, msg })
+	// the header is already there
+	s.Use(func(res http.ResponseWriter) { res.Header().Set("Vary", "accept") })
+	s.Get("/other", func() map[string]int { return map[string]int{"a": 1} })
+
+	tests := []struct {
+		path        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/msg", "", 200, MediaType, string(pb)},
+		{"/msg", "*/*", 200, MediaType, string(pb)},
+		{"/msg", "application/yaml", 200, MediaType, string(pb)},
+		{"/msg", "application/json", 200, "application/json; charset=utf-8", string(js)},
+		{"/msg", "application/json;q=0.5, application/x-protobuf", 200, MediaType, string(pb)},
+		// negotiated among the Encoders of messages only
+		{"/msg", "application/xml, application/json;q=0.5", 200, "application/json; charset=utf-8", string(js)},
+		{"/msg", "text/*, application/json;q=0.1", 200, "application/json; charset=utf-8", string(js)},
+		{"/msg", "application/*", 200, MediaType, string(pb)},
+		{"/created", "application/json", 201, "application/json; charset=utf-8", string(js)},
+		{"/other", MediaType, 200, "application/yaml; charset=utf-8", "a: 1\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res := s.ServeTest(req)
+		if res.Code != test.status {
+			t.Errorf("%s %q: got status %d, want %d", test.path, test.accept, res.Code, test.status)
+		}
+		if ct := res.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s %q: got Content-Type %q, want %q", test.path, test.accept, ct, test.contentType)
+		}
+		if test.body != "" && res.Body.String() != test.body {
+			t.Errorf("%s %q: got body %q, want %q", test.path, test.accept, res.Body.String(), test.body)
+		}
+		if vary := res.Header().Values("Vary"); len(vary) != 1 || !strings.EqualFold(vary[0], "Accept") {
+			t.Errorf("%s %q: got Vary %q", test.path, test.accept, vary)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package yawf
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// TrustedProxies is the list of networks whose forwarding headers (X-Forwarded-Proto and
+// friends) are believed. It is mapped on the server by SetTrustedProxies.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of CIDRs or bare IP addresses into TrustedProxies.
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains returns whether the given address, with or without a port, belongs to a trusted proxy.
+func (p TrustedProxies) Contains(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustsPeer returns whether the request came directly from a trusted proxy.
+func (c *context) trustsPeer(req *http.Request) bool {
+	rv := c.Get(reflect.TypeOf(TrustedProxies(nil)))
+	if !rv.IsValid() {
+		return false
+	}
+	return rv.Interface().(TrustedProxies).Contains(req.RemoteAddr)
+}
+
+func (c *context) ClientIP() string {
+	req := c.request()
+	if req == nil {
+		return ""
+	}
+	peer := stripPort(req.RemoteAddr)
+	if !c.trustsPeer(req) {
+		return peer
+	}
+	proxies := c.Get(reflect.TypeOf(TrustedProxies(nil))).Interface().(TrustedProxies)
+
+	var hops []string
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = forwardedFor(forwarded)
+	} else if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, value := range xff {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	} else if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); ip != "" {
+		hops = []string{ip}
+	}
+	// the closest hops were appended by the trusted proxies, the first untrusted one is the client
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := stripPort(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if i == 0 || !proxies.Contains(hop) {
+			return hop
+		}
+	}
+	return peer
+}
+
+// forwardedFor returns the for= addresses of the elements of Forwarded headers, see RFC 7239.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(v, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port from an address, as well as the brackets of an IPv6 address.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,79 @@
+package yawf
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		peer    string
+		headers map[string]string
+		ip      string
+	}{
+		{"no proxy", nil, "198.51.100.1:1234", nil, "198.51.100.1"},
+		{"spoofed without trusted proxies", nil, "198.51.100.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Real-IP": "203.0.113.7", "Forwarded": "for=203.0.113.7"}, "198.51.100.1"},
+		{"spoofed through an untrusted peer", []string{"10.0.0.0/8"}, "198.51.100.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7"}, "198.51.100.1"},
+		{"trusted peer without headers", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"X-Forwarded-For", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"X-Forwarded-For through proxies", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2, 10.0.0.3"}, "203.0.113.7"},
+		{"X-Forwarded-For with a forged first hop", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"X-Forwarded-For all trusted", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"X-Forwarded-For garbage", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "unknown"}, "10.0.0.1"},
+		{"X-Real-IP", []string{"10.0.0.1"}, "10.0.0.1:1234",
+			map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"Forwarded", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"Forwarded": `for=203.0.113.7;proto=https, for="10.0.0.2:8080"`}, "203.0.113.7"},
+		{"Forwarded IPv6", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"Forwarded over X-Forwarded-For", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=203.0.113.7", "X-Forwarded-For": "203.0.113.8"}, "203.0.113.7"},
+		{"X-Forwarded-For over X-Real-IP", []string{"10.0.0.0/8"}, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Real-IP": "203.0.113.8"}, "203.0.113.7"},
+		{"IPv6 peer", []string{"2001:db8::/32"}, "[2001:db8::2]:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		if err := s.SetTrustedProxies(test.proxies...); err != nil {
+			t.Fatal(err)
+		}
+		s.Get("/", func(c Context) string { return c.ClientIP() })
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.peer
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if ip := s.ServeTest(req).Body.String(); ip != test.ip {
+			t.Errorf("%s: got %q, want %q", test.name, ip, test.ip)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "192.0.2.1", "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3": true, "10.1.2.3:80": true, "192.0.2.1": true, "192.0.2.2": false,
+		"[2001:db8::1]:443": true, "2001:db8::2": false, "garbage": false,
+	} {
+		if proxies.Contains(addr) != want {
+			t.Errorf("Contains(%q) = %t, want %t", addr, !want, want)
+		}
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("an invalid CIDR was accepted")
+	}
+}
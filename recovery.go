@@ -0,0 +1,33 @@
+package yawf
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery returns a middleware that recovers from any panics and logs them. Unless the
+// response has already been written, it answers with a 500 through the ErrorHandler.
+func Recovery() Handler {
+	return func(c Context, log *log.Logger) {
+		defer func() {
+			if e := recover(); e != nil {
+				err, ok := e.(error)
+				if !ok {
+					err = fmt.Errorf("%v", e)
+				}
+				log.Printf("PANIC: %s\n%s", err, debug.Stack())
+
+				c.Stop()
+				if !c.Written() {
+					respondError(c, http.StatusInternalServerError, err)
+				} else {
+					c.Error(err)
+				}
+			}
+		}()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+package yawf
+
+import (
+	"fmt"
+	"github.com/codegangsta/inject"
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+func init() {
+	RegisterReturnType(reflect.TypeOf(Redirection{}), writeRedirection)
+}
+
+// Redirection is a redirect returned by a handler, see Redirect and RedirectToRoute. Its Code
+// takes precedence over a status returned along with it.
+type Redirection struct {
+	Code     int
+	Location string
+	// route and params are those of RedirectToRoute, rendered with Routes.URLFor.
+	route  string
+	params []interface{}
+}
+
+// Redirect returns a redirect with the status, e.g. http.StatusFound, to the location, for a
+// handler to return rather than calling http.Redirect, e.g. return yawf.Redirect(302, "/login").
+func Redirect(code int, location string) Redirection {
+	return Redirection{Code: code, Location: location}
+}
+
+// RedirectToRoute returns a redirect with the status to the URL of the named route, rendered by
+// Routes.URLFor with the params once returned.
+func RedirectToRoute(code int, name string, params ...interface{}) Redirection {
+	return Redirection{Code: code, route: name, params: params}
+}
+
+// writeRedirection is the ReturnRenderer of Redirections. A redirect without a status is a
+// 302 Found.
+func writeRedirection(ctx Context, res http.ResponseWriter, status int, v interface{}) {
+	redirect := v.(Redirection)
+	location := redirect.Location
+	if redirect.route != "" {
+		routes := ctx.Get(inject.InterfaceOf((*Routes)(nil))).Interface().(Routes)
+		location = routes.URLFor(redirect.route, redirect.params...)
+	}
+	code := redirect.Code
+	if code == 0 {
+		code = http.StatusFound
+	}
+	http.Redirect(res, requestOf(ctx), location, code)
+}
+
+func (r *router) Redirect(from string, to string, status int) Route {
+	return r.addRoute("*", from, []Handler{func(res http.ResponseWriter, req *http.Request) {
+		http.Redirect(res, req, withQuery(to, req), status)
+	}})
+}
+
+func (r *router) RedirectPattern(from string, to string, status int) Route {
+	target := newRoute("*", to, nil)
+	// the parameters of the group prefix and host count along with those of the pattern
+	current := r.currentGroup()
+	params := map[string]string{}
+	for _, name := range newRoute("*", joinPattern(current.pattern, from), nil).regex.SubexpNames() {
+		params[name] = ""
+	}
+	if current.host != nil {
+		for _, name := range current.host.SubexpNames() {
+			params[name] = ""
+		}
+	}
+	_, names, _ := target.urlWithMap(params)
+	for _, name := range names {
+		if _, ok := params[name]; !ok {
+			panic(fmt.Sprintf("redirect target %s has a parameter %q that %s doesn't", to, name, from))
+		}
+	}
+	return r.addRoute("*", from, []Handler{func(res http.ResponseWriter, req *http.Request, params PathParams) {
+		escaped := make(map[string]string, len(params))
+		for name, val := range params {
+			escaped[name] = (&url.URL{Path: val}).EscapedPath()
+		}
+		u, _, _ := target.urlWithMap(escaped)
+		http.Redirect(res, req, withQuery(u, req), status)
+	}})
+}
+
+// withQuery appends the query string of the request to a redirect target that has none.
+func withQuery(target string, req *http.Request) string {
+	if req.URL.RawQuery == "" {
+		return target
+	}
+	if u, err := url.Parse(target); err == nil && u.RawQuery == "" && u.Fragment == "" {
+		return target + "?" + req.URL.RawQuery
+	}
+	return target
+}
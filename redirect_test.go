@@ -0,0 +1,24 @@
+package yawf
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirectPattern(t *testing.T) {
+	s := newTestServer()
+	s.Group("/orgs/:org", func(r Router) {
+		r.RedirectPattern("/old/:id", "/orgs/:org/new/:id", http.StatusMovedPermanently)
+	})
+	rec := serve(s, "GET", "/orgs/acme/old/7?tab=1")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/orgs/acme/new/7?tab=1" {
+		t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a target parameter missing from the pattern didn't panic")
+		}
+	}()
+	s.RedirectPattern("/old/:id", "/new/:slug", http.StatusMovedPermanently)
+}
@@ -0,0 +1,379 @@
+package yawf
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder encodes a value returned by a handler into the body of the response.
+type Encoder func(io.Writer, interface{}) error
+
+// encoders holds the registered Encoders by media type. JSON, XML, YAML (see yaml.go),
+// MessagePack (see msgpack.go) and plain text are supported out of the box, protobuf by
+// importing the protobuf package.
+var encoders = map[string]Encoder{
+	"application/json": func(w io.Writer, v interface{}) error {
+		return json.NewEncoder(w).Encode(v)
+	},
+	"application/xml": func(w io.Writer, v interface{}) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(v)
+	},
+	"text/plain": encodeText,
+}
+
+// encodeText is the Encoder of text/plain. It only encodes fmt.Stringers and scalars, since
+// printing other values, e.g. structs, would disclose the fields the other Encoders leave out,
+// such as those tagged `json:"-"`.
+func encodeText(w io.Writer, v interface{}) error {
+	if _, ok := v.(fmt.Stringer); !ok {
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Bool, reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("%T can't be encoded as text/plain", v)
+		}
+	}
+	_, err := fmt.Fprint(w, v)
+	return err
+}
+
+// RegisterEncoder registers the Encoder used for the values returned by handlers when the
+// request accepts the given media type, e.g. "application/yaml". It replaces any Encoder
+// previously registered for it, and must be called before the server serves requests, e.g.
+// from an init function.
+func RegisterEncoder(mediaType string, fn func(io.Writer, interface{}) error) {
+	encoders[strings.ToLower(mediaType)] = fn
+}
+
+// typeEncoding holds the Encoders registered for the values of a type by RegisterTypeEncoders.
+type typeEncoding struct {
+	t                reflect.Type
+	defaultMediaType string
+	encoders         map[string]Encoder
+}
+
+// typeEncodings holds the typeEncodings in the order they were registered in, guarded by
+// typeEncodingsMu since types may be registered while requests are being served.
+var (
+	typeEncodings   []typeEncoding
+	typeEncodingsMu sync.RWMutex
+)
+
+// RegisterTypeEncoders registers the Encoders used, by media type, for the values returned by
+// handlers of the type, or implementing it if it is an interface type, instead of the other
+// Encoders. The default media type is the one used when the request prefers none of them,
+// whatever the RenderOptions. E.g. the protobuf package registers those of proto.Message. It
+// replaces any Encoders previously registered for the type.
+func RegisterTypeEncoders(t reflect.Type, defaultMediaType string, typed map[string]Encoder) {
+	te := typeEncoding{t, strings.ToLower(defaultMediaType), make(map[string]Encoder, len(typed))}
+	for mediaType, fn := range typed {
+		te.encoders[strings.ToLower(mediaType)] = fn
+	}
+	typeEncodingsMu.Lock()
+	defer typeEncodingsMu.Unlock()
+	for i := range typeEncodings {
+		if typeEncodings[i].t == t {
+			typeEncodings[i] = te
+			return
+		}
+	}
+	typeEncodings = append(typeEncodings, te)
+}
+
+// typeEncodingOf returns the typeEncoding of the values of the type, if any.
+func typeEncodingOf(vt reflect.Type) (typeEncoding, bool) {
+	typeEncodingsMu.RLock()
+	defer typeEncodingsMu.RUnlock()
+	for _, te := range typeEncodings {
+		if vt == te.t || te.t.Kind() == reflect.Interface && vt.Implements(te.t) {
+			return te, true
+		}
+	}
+	return typeEncoding{}, false
+}
+
+// hasTypeEncoding returns whether Encoders are registered for the values of the type.
+func hasTypeEncoding(vt reflect.Type) bool {
+	_, ok := typeEncodingOf(vt)
+	return ok
+}
+
+// encodeTyped encodes a value returned by a handler with the Encoders registered for its type
+// by RegisterTypeEncoders, if any, and reports whether there are.
+func encodeTyped(ctx Context, res http.ResponseWriter, v interface{}) (*bytes.Buffer, bool) {
+	if v == nil {
+		return nil, false
+	}
+	te, ok := typeEncodingOf(reflect.TypeOf(v))
+	if !ok {
+		return nil, false
+	}
+	mediaType := te.defaultMediaType
+	if req := requestOf(ctx); req != nil {
+		mediaType = negotiateMediaType(req.Header.Get("Accept"), mediaType, te.encoders)
+	}
+	addVary(res.Header(), "Accept")
+
+	body := getBuffer()
+	if err := te.encoders[mediaType](body, v); err != nil {
+		panic(err)
+	}
+	if res.Header().Get("Content-Type") == "" {
+		res.Header().Set("Content-Type", contentType(mediaType))
+	}
+	return body, true
+}
+
+// Encoded is a value returned by a handler along with the media type of the Encoder it is
+// encoded with, whatever the request accepts. See XML, YAML and Msgpack.
+type Encoded struct {
+	MediaType string
+	Value     interface{}
+}
+
+// XML wraps a value returned by a handler to have it encoded as XML.
+func XML(v interface{}) Encoded {
+	return Encoded{"application/xml", v}
+}
+
+// RenderOptions configures how the values returned by handlers, other than strings, byte
+// slices and readers, are encoded. It is mapped on the server by SetRenderOptions, and a
+// group can map its own from a GroupUse handler.
+type RenderOptions struct {
+	// DefaultMediaType is the media type of the Encoder used when the request accepts any
+	// media type or none of those of the registered Encoders. It is application/json when
+	// empty.
+	DefaultMediaType string
+	// Indent indents the JSON bodies with it when set, e.g. "  " in development.
+	Indent string
+	// PrettyParam is the name of a query parameter indenting the JSON bodies of the requests
+	// setting it to a true value, e.g. "pretty" for ?pretty=1, with two spaces.
+	PrettyParam string
+}
+
+// renderOptions returns the mapped RenderOptions, with the defaults applied.
+func renderOptions(ctx Context) RenderOptions {
+	var options RenderOptions
+	if ov := ctx.Get(reflect.TypeOf(options)); ov.IsValid() {
+		options = ov.Interface().(RenderOptions)
+	}
+	if _, ok := encoders[options.DefaultMediaType]; !ok {
+		options.DefaultMediaType = "application/json"
+	}
+	return options
+}
+
+// buffers pools the buffers the bodies are encoded into, so that encoding a response doesn't
+// grow a new buffer to its size every time.
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the capacity above which a buffer isn't pooled again, so that a few large
+// responses don't keep their memory around.
+const maxPooledBuffer = 64 << 10
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to the pool once its bytes have been written.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buffers.Put(buf)
+	}
+}
+
+// encodeValue encodes a value returned by a handler with the Encoder of the media type the
+// request prefers, or the one of an Encoded value, setting the Content-Type of the response
+// unless already set. Values the preferred Encoder fails on, e.g. maps for XML, are encoded
+// with the default one. The body is returned in a pooled buffer, to be given back with
+// putBuffer once written.
+func encodeValue(ctx Context, res http.ResponseWriter, v interface{}) *bytes.Buffer {
+	if body, ok := encodeTyped(ctx, res, v); ok {
+		return body
+	}
+
+	options := renderOptions(ctx)
+	req := requestOf(ctx)
+	body := getBuffer()
+	var mediaType string
+	if encoded, ok := v.(Encoded); ok {
+		mediaType = encoded.MediaType
+		encode, ok := encoders[mediaType]
+		if !ok {
+			panic(fmt.Errorf("no encoder for media type %s", mediaType))
+		}
+		if err := encode(body, encoded.Value); err != nil {
+			panic(err)
+		}
+	} else {
+		mediaType = options.DefaultMediaType
+		if req != nil {
+			mediaType = negotiateMediaType(req.Header.Get("Accept"), mediaType, encoders)
+		}
+		addVary(res.Header(), "Accept")
+
+		err := encoders[mediaType](body, v)
+		if err != nil && mediaType != options.DefaultMediaType {
+			mediaType = options.DefaultMediaType
+			body.Reset()
+			err = encoders[mediaType](body, v)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if res.Header().Get("Content-Type") == "" {
+		res.Header().Set("Content-Type", contentType(mediaType))
+	}
+	if indent := options.indent(req); indent != "" && isJSON(mediaType) {
+		indented := getBuffer()
+		if json.Indent(indented, body.Bytes(), "", indent) == nil {
+			putBuffer(body)
+			return indented
+		}
+		putBuffer(indented)
+	}
+	return body
+}
+
+// indent returns the indentation of the JSON bodies of the request, if any.
+func (options RenderOptions) indent(req *http.Request) string {
+	if options.Indent != "" {
+		return options.Indent
+	}
+	if options.PrettyParam != "" && req != nil {
+		if getBool(req.URL.Query(), options.PrettyParam, false) {
+			return "  "
+		}
+	}
+	return ""
+}
+
+// contentType returns the Content-Type of a body of the media type, with the UTF-8 charset
+// for textual media types.
+func contentType(mediaType string) string {
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || strings.HasSuffix(mediaType, "yaml") {
+		return mediaType + "; charset=utf-8"
+	}
+	return mediaType
+}
+
+// acceptRange is a media range of an Accept header with its quality.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateMediaType returns the media type of the Encoders the Accept header prefers, or the
+// default one.
+func negotiateMediaType(accept string, def string, available map[string]Encoder) string {
+	if accept == "" {
+		return def
+	}
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.q <= 0 {
+			break
+		}
+		if r.mediaType == "*/*" {
+			return def
+		}
+		if prefix := strings.TrimSuffix(r.mediaType, "*"); prefix != r.mediaType {
+			if strings.HasPrefix(def, prefix) {
+				return def
+			}
+			if mediaType := encoderWithPrefix(prefix, available); mediaType != "" {
+				return mediaType
+			}
+			continue
+		}
+		if _, ok := available[r.mediaType]; ok {
+			return r.mediaType
+		}
+	}
+	return def
+}
+
+// encoderWithPrefix returns the first media type, in alphabetical order, of the Encoders that
+// has the prefix, e.g. "text/".
+func encoderWithPrefix(prefix string, available map[string]Encoder) string {
+	var matches []string
+	for mediaType := range available {
+		if strings.HasPrefix(mediaType, prefix) {
+			matches = append(matches, mediaType)
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return matches[0]
+}
+
+// addVary adds a field name to the Vary header, unless it is already there.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// requestOf returns the request mapped on the context, if any.
+func requestOf(ctx Context) *http.Request {
+	rv := ctx.Get(reflect.TypeOf((*http.Request)(nil)))
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface().(*http.Request)
+}
+
+func (c *context) XML(status int, v interface{}) {
+	c.render(status, XML(v))
+}
+
+// render writes the status and the encoded value as the response.
+func (c *context) render(status int, v Encoded) {
+	body := encodeValue(c, c.rw, v)
+	defer putBuffer(body)
+	c.rw.WriteHeader(status)
+	c.rw.Write(body.Bytes())
+}
@@ -0,0 +1,129 @@
+package yawf
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type account struct {
+	Name     string
+	Password string `json:"-"`
+}
+
+func TestEncodeTextFallsBack(t *testing.T) {
+	s := newTestServer()
+	s.Get("/account", func() account { return account{"alice", "$2a$10$secret"} })
+	s.Get("/count", func() int { return 42 })
+	s.Get("/delay", func() time.Duration { return time.Second })
+
+	tests := []struct {
+		path        string
+		accept      string
+		body        string
+		contentType string
+	}{
+		{"/account", "text/plain", `{"Name":"alice"}` + "\n", "application/json; charset=utf-8"},
+		{"/account", "text/*", `{"Name":"alice"}` + "\n", "application/json; charset=utf-8"},
+		{"/count", "text/plain", "42", "text/plain; charset=utf-8"},
+		{"/delay", "text/plain", "1s", "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept", test.accept)
+		rec := s.ServeTest(req)
+		if rec.Body.String() != test.body || rec.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s with %s: got %q as %s, want %q as %s", test.path, test.accept,
+				rec.Body.String(), rec.Header().Get("Content-Type"), test.body, test.contentType)
+		}
+		if strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s with %s: the password leaked", test.path, test.accept)
+		}
+	}
+}
+
+func TestPooledBuffersConcurrently(t *testing.T) {
+	s := newTestServer()
+	s.SetRenderOptions(RenderOptions{PrettyParam: "pretty"})
+	s.Get("/n/:n", func(p PathParams) map[string]string {
+		// large enough for some buffers to grow past maxPooledBuffer
+		return map[string]string{"n": p["n"], "pad": strings.Repeat(p["n"], len(p["n"])*10000)}
+	})
+	s.Get("/xml/:n", func(p PathParams) Encoded { return XML(account{Name: p["n"]}) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := fmt.Sprint(i)
+			pad := strings.Repeat(n, len(n)*10000)
+			for j := 0; j < 20; j++ {
+				var target, want string
+				switch j % 3 {
+				case 0:
+					target, want = "/n/"+n, `{"n":"`+n+`","pad":"`+pad+`"}`+"\n"
+				case 1:
+					target, want = "/n/"+n+"?pretty=1", "{\n  \"n\": \""+n+"\",\n  \"pad\": \""+pad+"\"\n}\n"
+				default:
+					target, want = "/xml/"+n, `<?xml version="1.0" encoding="UTF-8"?>`+"\n<account><Name>"+n+"</Name><Password></Password></account>"
+				}
+				if body := serve(s, "GET", target).Body.String(); body != want {
+					t.Errorf("%s: got %.80q..., want %.80q...", target, body, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	s := newTestServer()
+	s.Get("/", func() []account {
+		accounts := make([]account, 100)
+		for i := range accounts {
+			accounts[i].Name = fmt.Sprint("account ", i)
+		}
+		return accounts
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ServeTest(req)
+	}
+}
+
+func TestVaryAccept(t *testing.T) {
+	s := newTestServer()
+	s.Get("/account", func() account { return account{Name: "alice"} })
+	s.Get("/cached", func(res http.ResponseWriter) account {
+		res.Header().Set("Vary", "Accept-Encoding, accept")
+		return account{Name: "alice"}
+	})
+	s.Get("/any", func(res http.ResponseWriter) account {
+		res.Header().Set("Vary", "*")
+		return account{Name: "alice"}
+	})
+	s.Get("/encoding", func(res http.ResponseWriter) account {
+		res.Header().Set("Vary", "Accept-Encoding")
+		return account{Name: "alice"}
+	})
+
+	tests := map[string][]string{
+		"/account":  {"Accept"},
+		"/cached":   {"Accept-Encoding, accept"},
+		"/any":      {"*"},
+		"/encoding": {"Accept-Encoding", "Accept"},
+	}
+	for path, want := range tests {
+		if vary := serve(s, "GET", path).Header().Values("Vary"); fmt.Sprint(vary) != fmt.Sprint(want) {
+			t.Errorf("%s: got Vary %q, want %q", path, vary, want)
+		}
+	}
+}
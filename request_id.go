@@ -0,0 +1,40 @@
+package yawf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestID identifies a request across the logs of the services it goes through. It is
+// taken from the X-Request-ID header of the request when it has a valid one, generated
+// otherwise, then mapped on the context of the request and echoed in the X-Request-ID
+// header of the response.
+type RequestID string
+
+// maxRequestIDLength bounds the size of the request IDs adopted from the requests.
+const maxRequestIDLength = 128
+
+// requestID returns the ID of the request, adopted or generated.
+func requestID(req *http.Request) RequestID {
+	if id := req.Header.Get("X-Request-ID"); validRequestID(id) {
+		return RequestID(id)
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return RequestID(hex.EncodeToString(b[:]))
+}
+
+// validRequestID returns whether an ID sent by a client can be adopted, i.e. is a token that
+// can't inject anything into the logs or the headers of the response.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !isTokenChar(id[i]) {
+			return false
+		}
+	}
+	return true
+}
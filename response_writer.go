@@ -14,6 +14,9 @@ type ResponseWriter interface {
 	http.ResponseWriter
 	http.Flusher
 	http.Hijacker
+	// Push initiates an HTTP/2 server push, returning http.ErrNotSupported when the wrapped
+	// http.ResponseWriter isn't an http.Pusher, e.g. for HTTP/1 connections.
+	http.Pusher
 	// Status returns the status code of the response or 0 if the response has not been written.
 	Status() int
 	// Written returns whether or not the ResponseWriter has been written.
@@ -23,6 +26,13 @@ type ResponseWriter interface {
 	// Before allows for a function to be called before the ResponseWriter has been written to. This is
 	// useful for setting headers or any other operations that must happen before a response has been written.
 	Before(BeforeFunc)
+	// Hijacked returns whether the underlying connection has been taken over by a handler, in which
+	// case the body must not be touched anymore. A hijacked ResponseWriter also reports itself as Written.
+	// A response streamed by a handler, e.g. with SSEvent, counts as hijacked as well.
+	Hijacked() bool
+	// Unwrap returns the wrapped http.ResponseWriter, following the convention of
+	// http.ResponseController for reaching capabilities the wrapper doesn't forward.
+	Unwrap() http.ResponseWriter
 }
 
 // BeforeFunc is a function that is called before the ResponseWriter has been written to.
@@ -30,7 +40,7 @@ type BeforeFunc func(ResponseWriter)
 
 // NewResponseWriter creates a ResponseWriter that wraps an http.ResponseWriter
 func NewResponseWriter(res http.ResponseWriter) ResponseWriter {
-	newRw := responseWriter{res, 0, false, 0, nil}
+	newRw := responseWriter{ResponseWriter: res}
 	if cn, ok := res.(http.CloseNotifier); ok {
 		return &closeNotifyResponseWriter{newRw, cn}
 	}
@@ -43,10 +53,13 @@ type responseWriter struct {
 	headerWritten bool
 	size          int
 	beforeFuncs   []BeforeFunc
+	hijacked      bool
+	// streamed is whether a handler streams the response, see markStreamed.
+	streamed bool
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
-	if !rw.headerWritten {
+	if !rw.headerWritten && !rw.hijacked {
 		rw.callBefore()
 		rw.ResponseWriter.WriteHeader(s)
 		rw.headerWritten = true
@@ -55,6 +68,9 @@ func (rw *responseWriter) WriteHeader(s int) {
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !rw.Written() {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		rw.WriteHeader(http.StatusOK)
@@ -73,7 +89,31 @@ func (rw *responseWriter) Size() int {
 }
 
 func (rw *responseWriter) Written() bool {
-	return rw.status != 0
+	return rw.status != 0 || rw.hijacked
+}
+
+func (rw *responseWriter) Hijacked() bool {
+	return rw.hijacked || rw.streamed
+}
+
+// markStreamed marks the response as streamed by a handler, so that the middlewares leave its
+// body alone as they do for a hijacked connection.
+func markStreamed(w http.ResponseWriter) {
+	for {
+		if rw, ok := w.(*responseWriter); ok {
+			rw.streamed = true
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 func (rw *responseWriter) Before(before BeforeFunc) {
@@ -85,7 +125,11 @@ func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if !ok {
 		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
 	}
-	return hijacker.Hijack()
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, buf, err
 }
 
 func (rw *responseWriter) callBefore() {
@@ -97,10 +141,22 @@ func (rw *responseWriter) callBefore() {
 func (rw *responseWriter) Flush() {
 	flusher, ok := rw.ResponseWriter.(http.Flusher)
 	if ok {
+		// flushing sends the headers with a 200 if they haven't been written yet
+		if !rw.Written() {
+			rw.WriteHeader(http.StatusOK)
+		}
 		flusher.Flush()
 	}
 }
 
+func (rw *responseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := rw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
 type closeNotifyResponseWriter struct {
 	responseWriter
 	closeNotifier http.CloseNotifier
@@ -0,0 +1,77 @@
+package yawf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHijackUnderLogger(t *testing.T) {
+	var logs, errors bytes.Buffer
+	s := New()
+	s.SetLogger(log.New(&logs, "", 0))
+	// the handlers return once the client has its response, the logs must wait for them
+	done := make(chan struct{})
+	s.Use(func(c Context) {
+		defer close(done)
+		c.Next()
+	})
+	s.Use(Logger())
+	s.Get("/raw", func(c Context) {
+		conn, buf, err := c.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nraw")
+		buf.Flush()
+	})
+
+	ts := httptest.NewUnstartedServer(s.(http.Handler))
+	ts.Config.ErrorLog = log.New(&errors, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "raw" {
+		t.Errorf("got body %q, want %q", body, "raw")
+	}
+	<-done
+	ts.Close()
+	if errors.Len() > 0 {
+		t.Errorf("the server complained: %s", errors.String())
+	}
+	if !strings.Contains(logs.String(), "Completed hijacked") {
+		t.Errorf("the hijacked connection wasn't logged as such: %s", logs.String())
+	}
+}
+
+func TestSSEventHijacked(t *testing.T) {
+	s := newTestServer()
+	var hijacked bool
+	s.Use(func(c Context, res http.ResponseWriter) {
+		c.Next()
+		hijacked = res.(ResponseWriter).Hijacked()
+	})
+	s.Get("/events", func(c Context) {
+		c.SSEvent("ping", "1")
+	})
+
+	rec := serve(s, "GET", "/events")
+	if rec.Body.String() != "event: ping\ndata: 1\n\n" {
+		t.Errorf("got body %q", rec.Body.String())
+	}
+	if !hijacked {
+		t.Error("a streamed response isn't reported as hijacked")
+	}
+}
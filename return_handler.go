@@ -1,10 +1,12 @@
 package yawf
 
 import (
-	"encoding/json"
 	"github.com/codegangsta/inject"
+	"io"
+	"log"
 	"net/http"
 	"reflect"
+	"sync"
 )
 
 // ReturnHandler is a service that Yawf provides that is called
@@ -18,6 +20,11 @@ func defaultRouterReturnHandler() RouterReturnHandler {
 	return func(ctx Context, vals []reflect.Value) {
 		rv := ctx.Get(inject.InterfaceOf((*http.ResponseWriter)(nil)))
 		res := rv.Interface().(http.ResponseWriter)
+		vals, err := splitError(vals)
+		if err != nil {
+			respondError(ctx, errorStatus(err), err)
+			return
+		}
 		if len(vals) == 0 || len(vals) >= 1 && vals[0].Kind() == reflect.Bool && vals[0].Bool() {
 			return
 		}
@@ -27,39 +34,70 @@ func defaultRouterReturnHandler() RouterReturnHandler {
 			return
 		}
 
-		var responseVal reflect.Value = reflect.ValueOf("")
-		if len(vals) > 1 {
-			var status int = 200
-			if vals[0].Kind() == reflect.Int {
-				status = int(vals[0].Int())
+		writeReturn(ctx, res, vals)
+	}
+}
+
+// writeReturn writes the values returned by a handler to the response. The recognized
+// forms are (body), (status, body) and (status, headers, body), where headers is a Headers
+// or any other map[string]string whose entries are set on the response before the status.
+func writeReturn(ctx Context, res http.ResponseWriter, vals []reflect.Value) {
+	var responseVal reflect.Value = reflect.ValueOf("")
+	var status int
+	if len(vals) > 1 {
+		status = routeDefaultStatus(ctx)
+		if vals[0].Kind() == reflect.Int {
+			status = int(vals[0].Int())
+		}
+		responseVal = vals[1]
+		if len(vals) > 2 && isHeaderMap(vals[1]) {
+			for _, key := range vals[1].MapKeys() {
+				res.Header().Set(key.String(), vals[1].MapIndex(key).String())
 			}
-			res.WriteHeader(status)
-			responseVal = vals[1]
-		} else if len(vals) > 0 {
-			responseVal = vals[0]
+			responseVal = vals[2]
 		}
-		if canDeref(responseVal) {
-			responseVal = responseVal.Elem()
+	} else if len(vals) > 0 {
+		if defaultStatus := routeDefaultStatus(ctx); defaultStatus != http.StatusOK {
+			status = defaultStatus
 		}
+		responseVal = vals[0]
+	}
+	writeValue(ctx, res, status, responseVal)
+}
 
-		if isByteSlice(responseVal) {
-			res.Write(responseVal.Bytes())
-		} else if isString(responseVal) {
-			res.Write([]byte(responseVal.String()))
-		} else {
-			bytes, err := json.Marshal(responseVal.Interface())
-			if err != nil {
-				panic(err)
+// writeStatus writes the status of the response unless it has already been written, in
+// which case the new status is logged and dropped rather than corrupting the response.
+func writeStatus(ctx Context, res http.ResponseWriter, status int) {
+	if rw, ok := res.(ResponseWriter); ok && rw.Written() {
+		if rw.Status() != status {
+			if lv := ctx.Get(reflect.TypeOf((*log.Logger)(nil))); lv.IsValid() {
+				lv.Interface().(*log.Logger).Printf("status %d ignored, the response was already written with status %d", status, rw.Status())
 			}
-			res.Write(bytes)
 		}
+		return
+	}
+	res.WriteHeader(status)
+}
+
+// routeDefaultStatus returns the status the matched route responds with when its handlers
+// don't return one.
+func routeDefaultStatus(ctx Context) int {
+	if route := ctx.Route(); route != nil && route.DefaultStatus() != 0 {
+		return route.DefaultStatus()
 	}
+	return http.StatusOK
 }
 
 func defaultMiddlewareReturnHandler() MiddlewareReturnHandler {
 	return func(ctx Context, vals []reflect.Value) {
 		rv := ctx.Get(inject.InterfaceOf((*http.ResponseWriter)(nil)))
 		res := rv.Interface().(http.ResponseWriter)
+		vals, err := splitError(vals)
+		if err != nil {
+			ctx.Stop()
+			respondError(ctx, errorStatus(err), err)
+			return
+		}
 		if len(vals) == 0 || len(vals) >= 1 && vals[0].Kind() == reflect.Bool && vals[0].Bool() {
 			return
 		}
@@ -70,44 +108,139 @@ func defaultMiddlewareReturnHandler() MiddlewareReturnHandler {
 			return
 		}
 
-		var responseVal reflect.Value = reflect.ValueOf("")
-		if len(vals) > 1 {
-			var status int = 200
-			if vals[0].Kind() == reflect.Int {
-				status = int(vals[0].Int())
-			}
-			res.WriteHeader(status)
-			responseVal = vals[1]
-		} else if len(vals) > 0 {
-			responseVal = vals[0]
+		ctx.Stop()
+		writeReturn(ctx, res, vals)
+	}
+}
+
+// ReturnRenderer writes a value returned by a handler, of a type it is registered for by
+// RegisterReturnType, to the response. The status is the one returned along with the value
+// or the default one of the route, and 0 when there is none.
+type ReturnRenderer func(ctx Context, res http.ResponseWriter, status int, v interface{})
+
+// returnRenderers holds the ReturnRenderers registered by type, guarded by returnRenderersMu
+// since types may be registered while requests are being served.
+var (
+	returnRenderers   = map[reflect.Type]ReturnRenderer{}
+	returnRenderersMu sync.RWMutex
+)
+
+// RegisterReturnType registers the ReturnRenderer of the values of the type returned by
+// handlers, e.g. RegisterReturnType(reflect.TypeOf(Page{}), renderPage), consulted before the
+// default rendering. Pointers to values of the type are rendered by it as well, dereferenced,
+// unless their type has its own. It replaces any ReturnRenderer previously registered for the
+// type.
+func RegisterReturnType(t reflect.Type, renderer ReturnRenderer) {
+	returnRenderersMu.Lock()
+	defer returnRenderersMu.Unlock()
+	returnRenderers[t] = renderer
+}
+
+// returnRenderer returns the ReturnRenderer of a value returned by a handler, if any, along
+// with the value to render.
+func returnRenderer(val reflect.Value) (ReturnRenderer, reflect.Value, bool) {
+	returnRenderersMu.RLock()
+	defer returnRenderersMu.RUnlock()
+	if len(returnRenderers) == 0 {
+		return nil, val, false
+	}
+	for val.IsValid() {
+		if renderer, ok := returnRenderers[val.Type()]; ok {
+			return renderer, val, true
 		}
-		if canDeref(responseVal) {
-			responseVal = responseVal.Elem()
+		if !canDeref(val) || val.IsNil() {
+			break
 		}
+		val = val.Elem()
+	}
+	return nil, val, false
+}
 
-		ctx.Stop()
-		if isByteSlice(responseVal) {
-			res.Write(responseVal.Bytes())
-		} else if isString(responseVal) {
-			res.Write([]byte(responseVal.String()))
-		} else {
-			bytes, err := json.Marshal(responseVal.Interface())
-			if err != nil {
-				panic(err)
-			}
-			res.Write(bytes)
+// writeValue writes the status, unless 0, and a value returned by a handler to the response.
+// Values with a ReturnRenderer are written by it, readers are streamed and closed afterwards,
+// channels and iterators are streamed as JSON arrays, strings and byte slices are written as
+// is and anything else is encoded by encodeValue.
+func writeValue(ctx Context, res http.ResponseWriter, status int, val reflect.Value) {
+	if renderer, v, ok := returnRenderer(val); ok {
+		renderer(ctx, res, status, v.Interface())
+		return
+	}
+	if reader, ok := asReader(val); ok {
+		if status != 0 {
+			writeStatus(ctx, res, status)
+		}
+		io.Copy(res, reader)
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		return
+	}
+	if canDeref(val) && (val.Kind() != reflect.Ptr || !hasTypeEncoding(val.Type())) {
+		val = val.Elem()
+	}
+	if isSequence(val) {
+		writeSequence(ctx, res, status, val)
+		return
+	}
+
+	var body []byte
+	if isByteSlice(val) {
+		body = val.Bytes()
+	} else if isString(val) {
+		body = []byte(val.String())
+	} else {
+		var v interface{}
+		if val.IsValid() {
+			v = val.Interface()
 		}
+		buf := encodeValue(ctx, res, v)
+		defer putBuffer(buf)
+		body = buf.Bytes()
 	}
+	if status != 0 {
+		writeStatus(ctx, res, status)
+	}
+	res.Write(body)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// splitError removes an error returned last by a handler from its values, returning it unless
+// it is nil. Handlers returning an error, e.g. (T, error) or just error, have it answered
+// through the ErrorHandler with the status given by errorStatus, the other values being used
+// when it is nil.
+func splitError(vals []reflect.Value) ([]reflect.Value, error) {
+	n := len(vals)
+	if n == 0 || !vals[n-1].Type().Implements(errorType) {
+		return vals, nil
+	}
+	last := vals[n-1]
+	if canDeref(last) && last.IsNil() {
+		return vals[:n-1], nil
+	}
+	return vals[:n-1], last.Interface().(error)
 }
 
 func isString(val reflect.Value) bool {
 	return val.Kind() == reflect.String
 }
 
+func isHeaderMap(val reflect.Value) bool {
+	return val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String && val.Type().Elem().Kind() == reflect.String
+}
+
 func isByteSlice(val reflect.Value) bool {
 	return val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8
 }
 
+func asReader(val reflect.Value) (io.Reader, bool) {
+	if !val.IsValid() || !val.CanInterface() || canDeref(val) && val.IsNil() {
+		return nil, false
+	}
+	reader, ok := val.Interface().(io.Reader)
+	return reader, ok
+}
+
 func canDeref(val reflect.Value) bool {
 	return val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr
 }
@@ -0,0 +1,72 @@
+package yawf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteHeaderThenReturn(t *testing.T) {
+	var logs bytes.Buffer
+	s := New()
+	s.SetLogger(log.New(&logs, "", 0))
+	s.Get("/body", func(res http.ResponseWriter) string {
+		res.WriteHeader(http.StatusCreated)
+		return "created"
+	})
+	s.Get("/status", func(res http.ResponseWriter) (int, string) {
+		res.WriteHeader(http.StatusCreated)
+		return http.StatusOK, "created"
+	})
+
+	for _, path := range []string{"/body", "/status"} {
+		rec := serve(s, "GET", path)
+		if rec.Code != http.StatusCreated || rec.Body.String() != "created" {
+			t.Errorf("GET %s: got %d %q, want %d %q", path, rec.Code, rec.Body.String(), http.StatusCreated, "created")
+		}
+	}
+	if !strings.Contains(logs.String(), "status 200 ignored") {
+		t.Errorf("the dropped status wasn't logged: %q", logs.String())
+	}
+}
+
+// page and report are rendered by the ReturnRenderers TestRegisterWhileServing registers.
+type page struct{ title string }
+type report struct{ name string }
+
+func TestRegisterWhileServing(t *testing.T) {
+	s := newTestServer()
+	s.Get("/page", func() page { return page{"home"} })
+	s.Get("/report", func() report { return report{"sales"} })
+	RegisterReturnType(reflect.TypeOf(page{}), func(ctx Context, res http.ResponseWriter, status int, v interface{}) {
+		io.WriteString(res, "<h1>"+v.(page).title+"</h1>")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if body := serve(s, "GET", "/page").Body.String(); body != "<h1>home</h1>" {
+				t.Errorf("got %q", body)
+			}
+			serve(s, "GET", "/report")
+		}()
+		go func() {
+			defer wg.Done()
+			RegisterReturnType(reflect.TypeOf(report{}), func(ctx Context, res http.ResponseWriter, status int, v interface{}) {
+				io.WriteString(res, v.(report).name)
+			})
+			RegisterTypeEncoders(reflect.TypeOf(report{}), "text/plain", map[string]Encoder{"text/plain": encodeText})
+		}()
+	}
+	wg.Wait()
+	if body := serve(s, "GET", "/report").Body.String(); body != "sales" {
+		t.Errorf("got %q after registering", body)
+	}
+}
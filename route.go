@@ -3,54 +3,272 @@ package yawf
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Route is an interface representing a Route in Yawf's routing layer.
 type Route interface {
 	// URLWith returns a rendering of the Route's url with the given string params.
 	URLWith([]string) string
-	// SetName sets a name for the route.
-	SetName(string)
+	// SetName sets a name for the route, prefixed with the names of its enclosing named groups.
+	// It panics if another route of the router already has the name.
+	SetName(string) Route
 	// Name returns the name of the route.
 	Name() string
 	// Pattern returns the pattern of the route.
 	Pattern() string
-	// Method returns the method of the route.
+	// Method returns the method of the route, "*" for routes added with Any, or the methods
+	// joined by commas for routes added with Match.
 	Method() string
+	// IsWildcardMethod returns whether the route matches any HTTP method.
+	IsWildcardMethod() bool
+	// Methods returns the concrete HTTP methods the route answers to: every method of
+	// WildcardMethods for a wildcard route, and HEAD along with GET for a GET route.
+	Methods() []string
+	// SetMeta attaches an arbitrary value to the route under the given key.
+	SetMeta(string, interface{}) Route
+	// Meta returns the value stored under the given key and whether it was set.
+	Meta(string) (interface{}, bool)
+	// SetReturnHandler sets the RouterReturnHandler used for the values returned by the
+	// route's handlers instead of the server-wide one.
+	SetReturnHandler(RouterReturnHandler) Route
+	// SetDefaultStatus sets the status the route responds with when its handlers neither
+	// return a status nor write one, e.g. 204 for a DELETE route.
+	SetDefaultStatus(int) Route
+	// DefaultStatus returns the status set by SetDefaultStatus, or 0 if none was set.
+	DefaultStatus() int
+	// SetRawBody marks the route as reading the request body itself, e.g. as a stream, so that it
+	// isn't parsed into the FormParams, which are then empty.
+	SetRawBody(bool) Route
+	// RawBody returns whether the route reads the raw request body.
+	RawBody() bool
+	// SetParseForm has the request body parsed before the handlers run, even if none of them
+	// injects FormParams, so that they can use req.Form, req.PostForm and req.MultipartForm.
+	SetParseForm(bool) Route
+	// SetRawParams opts the route out of the unescaping of its parameters, whose PathParams are
+	// then as they appear in the escaped path, e.g. "a%2Fb" rather than "a/b". Constraints and
+	// types apply to the escaped values as well.
+	SetRawParams(bool) Route
+	// Where constrains the named parameter to values matching the regular expression as a whole,
+	// e.g. `[A-Z]{3}-\d+`. Requests whose value doesn't match aren't matched by the route.
+	Where(string, string) Route
+	// SetPriority sets the priority of the route, 0 by default. Of the routes matching a request
+	// equally well, the ones with the highest priority are preferred, then the most specific
+	// ones: a literal segment beats a parameter, which beats a wildcard, from left to right.
+	// Registration order only breaks the remaining ties.
+	SetPriority(int) Route
+	// Priority returns the priority of the route.
+	Priority() int
+	// SetTrailingSlash sets how the route treats a request path whose trailing slash differs
+	// from its pattern, instead of the policy of the router.
+	SetTrailingSlash(TrailingSlashPolicy) Route
+	// SetStrictSlash is a shorthand for SetTrailingSlash(TrailingSlashStrict), or for going back
+	// to the policy of the router when false. Routes whose patterns only differ by a trailing
+	// slash, e.g. "/resource" and "/resource/", can then each have their own handlers.
+	SetStrictSlash(bool) Route
+	// Before adds handlers that run before the route's own, after the ones of its groups.
+	Before(...Handler) Route
+	// After adds handlers that run once the route's handlers are done and the response is
+	// produced, e.g. to write it back to a cache. Their return values are ignored.
+	After(...Handler) Route
+	// Query restricts the route to requests whose query string has the parameter with the
+	// given value, or with any value if the value is empty. Of the routes matching a request,
+	// the ones with such conditions are preferred to the others, priority and specificity aside.
+	Query(string, string) Route
+	// Header is like Query for a header of the request. The value is compared with the header
+	// ignoring case and any parameters after a ";", so that "application/json" matches a
+	// Content-Type of "application/json; charset=utf-8".
+	Header(string, string) Route
+	// MatchFunc restricts the route to the requests for which the function returns true, once
+	// their method and path match. Other routes are tried for the requests it turns down.
+	MatchFunc(func(*http.Request) bool) Route
+	// Timeout makes the route answer with a 504 through the ErrorHandler when its handlers
+	// take longer than the duration, canceling the context of the request they get. Their
+	// response is buffered until they are done.
+	Timeout(time.Duration) Route
 }
 
+// TrailingSlashPolicy is how a request path that only differs from the pattern of a route
+// by a trailing slash, e.g. "/users/" for "/users" or the other way around, is treated.
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashPermissive matches the path as if it had the slash of the pattern. It is the default.
+	TrailingSlashPermissive TrailingSlashPolicy = iota
+	// TrailingSlashStrict doesn't match the path.
+	TrailingSlashStrict
+	// TrailingSlashRedirect redirects to the path with the slash of the pattern, with a 301 for
+	// GET and HEAD requests and a 308 otherwise so that the method and body are kept.
+	TrailingSlashRedirect
+)
+
 type route struct {
 	method   string
 	regex    *regexp.Regexp
 	handlers []Handler
+	before   []Handler
+	after    []Handler
 	pattern  string
 	name     string
+	meta     map[string]interface{}
+	group    *group
+
+	returnHandler RouterReturnHandler
+	defaultStatus int
+	paramTypes    map[string]string
+	constraints   map[string]*regexp.Regexp
+	rawBody       bool
+	parseForm     bool
+	rawParams     bool
+	priority      int
+	trailingSlash TrailingSlashPolicy
+	// ownSlash is whether trailingSlash overrides the policy of the router.
+	ownSlash bool
+	// methods are the methods of a route added with Match.
+	methods []string
+	// conditions are the checks other than the method and path the request must pass.
+	conditions []func(*http.Request) bool
+	// timeout is how long the handlers may run, if not 0.
+	timeout time.Duration
+	// specificity ranks the segments of the pattern, see patternSpecificity.
+	specificity []int
+	// raw is the pattern the route was added with, relative to its group. build, if set,
+	// returns the pattern and handlers of the route under a group prefix, for the routes that
+	// depend on it otherwise than by joining it with raw. See rebuilt.
+	raw   string
+	build func(prefix string) (string, []Handler)
+	// fallback is whether the route was added with Fallback.
+	fallback bool
+	// router is the router the route was added to, seq its registration order within it.
+	router *router
+	seq    int
 }
 
 var routeReg1 = regexp.MustCompile(`:[^/#?()\.\\]+`)
 var routeReg2 = regexp.MustCompile(`\*\*`)
 
+// optionalParamReg matches an optional trailing segment, written `/?:name?`, as in
+// `/articles/:year/?:month?/?:day?`. The parameter of a missing segment is absent from
+// the PathParams.
+var optionalParamReg = regexp.MustCompile(`/\?:[^/#?()\.\\]+\?`)
+
+// catchAllReg matches a named catch-all segment, as in `/static/*filepath`, which takes
+// the rest of the path like `**` but under the given name.
+var catchAllReg = regexp.MustCompile(`/\*[A-Za-z_]\w*`)
+
 func newRoute(method string, pattern string, handlers []Handler) *route {
-	route := route{method, nil, handlers, pattern, ""}
+	route := route{method: method, handlers: handlers, pattern: pattern}
+	route.paramTypes = parseParamTypes(pattern)
+	route.compile()
+	return &route
+}
+
+// compile compiles the regexp the pattern of the route matches paths with and ranks it.
+func (r *route) compile() {
+	// the last group captures the slash that may end the path, see matchPath
+	pattern := r.pattern
+	if pattern != "/" {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	r.regex = regexp.MustCompile(compilePattern(escapeNonASCII(pattern)) + `(\/?)`)
+	r.specificity = patternSpecificity(r.pattern)
+}
+
+const (
+	wildcardSegment = iota
+	paramSegment
+	staticSegment
+)
+
+// patternSpecificity ranks each segment of a pattern as static, param or wildcard, stopping
+// at the first wildcard as it stands for all the segments left.
+func patternSpecificity(pattern string) []int {
+	var kinds []int
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		switch {
+		case strings.Contains(segment, "*"):
+			return append(kinds, wildcardSegment)
+		case strings.ContainsAny(segment, ":(?"):
+			kinds = append(kinds, paramSegment)
+		default:
+			kinds = append(kinds, staticSegment)
+		}
+	}
+	return kinds
+}
+
+// precedes returns whether the route is tried before the other one when both may match a path:
+// the one with the highest priority first, then the most specific one, then the first added.
+func (r *route) precedes(o *route) bool {
+	if r.priority != o.priority {
+		return r.priority > o.priority
+	}
+	for i := 0; i < len(r.specificity) && i < len(o.specificity); i++ {
+		if r.specificity[i] != o.specificity[i] {
+			return r.specificity[i] > o.specificity[i]
+		}
+	}
+	if len(r.specificity) != len(o.specificity) {
+		return len(r.specificity) > len(o.specificity)
+	}
+	if len(r.conditions) != len(o.conditions) {
+		return len(r.conditions) > len(o.conditions)
+	}
+	return r.seq < o.seq
+}
+
+// escapeNonASCII percent-encodes the non-ASCII characters and the spaces of a pattern so that
+// it can be matched against escaped paths.
+func escapeNonASCII(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if c := pattern[i]; c < utf8.RuneSelf && c != ' ' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// compilePattern converts the `:name`, `*name` and `**` placeholders of a route pattern
+// into named regexp groups.
+func compilePattern(pattern string) string {
+	pattern = optionalParamReg.ReplaceAllStringFunc(pattern, func(m string) string {
+		name, _ := splitParam(m[2 : len(m)-1])
+		return fmt.Sprintf(`(?:/(?P<%s>[^/#?]+))?`, name)
+	})
+	pattern = catchAllReg.ReplaceAllStringFunc(pattern, func(m string) string {
+		return fmt.Sprintf(`/(?P<%s>[^#?]*)`, m[2:])
+	})
 	pattern = routeReg1.ReplaceAllStringFunc(pattern, func(m string) string {
-		return fmt.Sprintf(`(?P<%s>[^/#?]+)`, m[1:])
+		name, _ := splitParam(m)
+		return fmt.Sprintf(`(?P<%s>[^/#?]+)`, name)
 	})
 	var index int
 	pattern = routeReg2.ReplaceAllStringFunc(pattern, func(m string) string {
 		index++
 		return fmt.Sprintf(`(?P<_%d>[^#?]*)`, index)
 	})
-	pattern += `\/?`
-	route.regex = regexp.MustCompile(pattern)
-	return &route
+	return pattern
 }
 
+// WildcardMethods are the methods a route added with Any is reported to answer to.
+var WildcardMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
 type RouteMatch int
 
 const (
 	NoMatch RouteMatch = iota
+	// RedirectMatch is a match of the method and path but for a trailing slash, to be redirected.
+	RedirectMatch
+	// ParamMismatch is a match of the method and path whose typed parameters don't conform.
+	ParamMismatch
 	StarMatch
 	OverloadMatch
 	ExactMatch
@@ -61,11 +279,11 @@ func (r RouteMatch) BetterThan(o RouteMatch) bool {
 	return r > o
 }
 
-func (r route) MatchMethod(method string) RouteMatch {
+func (r *route) MatchMethod(method string) RouteMatch {
 	switch {
-	case method == r.method:
+	case method == r.method || hasMethod(r.methods, method):
 		return ExactMatch
-	case method == "HEAD" && r.method == "GET":
+	case method == "HEAD" && (r.method == "GET" || hasMethod(r.methods, "GET")):
 		return OverloadMatch
 	case r.method == "*":
 		return StarMatch
@@ -74,24 +292,95 @@ func (r route) MatchMethod(method string) RouteMatch {
 	}
 }
 
-func (r route) Match(method string, path string) (RouteMatch, map[string]string) {
+// Match matches the request method and escaped path, the given trailing slash policy of the
+// router applying unless the route has its own.
+func (r *route) Match(method string, path string, slash TrailingSlashPolicy) (RouteMatch, map[string]string) {
 	// add Any method matching support
 	match := r.MatchMethod(method)
 	if match == NoMatch {
 		return match, nil
 	}
 
-	matches := r.regex.FindStringSubmatch(path)
-	if len(matches) > 0 && matches[0] == path {
-		params := make(map[string]string)
-		for i, name := range r.regex.SubexpNames() {
-			if len(name) > 0 {
-				params[name] = matches[i]
+	params, slashMismatch := r.matchPath(path)
+	if params == nil {
+		return NoMatch, nil
+	}
+	for name, constraint := range r.constraints {
+		if val, ok := params[name]; ok && !constraint.MatchString(val) {
+			return NoMatch, nil
+		}
+	}
+	if slashMismatch {
+		switch r.slashPolicy(slash) {
+		case TrailingSlashStrict:
+			return NoMatch, nil
+		case TrailingSlashRedirect:
+			return RedirectMatch, params
+		}
+	}
+	if !r.validParams(params) {
+		return ParamMismatch, params
+	}
+	return match, params
+}
+
+// matchPath returns the params captured from the escaped path, or nil if the pattern doesn't
+// match it, and whether the path has a trailing slash that the pattern hasn't or the other way
+// around. Wildcards at the end of the pattern take any trailing slash.
+func (r *route) matchPath(path string) (map[string]string, bool) {
+	matches := r.regex.FindStringSubmatchIndex(path)
+	if len(matches) == 0 || matches[0] != 0 || matches[1] != len(path) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range r.regex.SubexpNames() {
+		// groups of missing optional segments don't match at all
+		if len(name) > 0 && matches[2*i] >= 0 {
+			params[name] = path[matches[2*i]:matches[2*i+1]]
+			if !r.rawParams {
+				params[name] = unescapeParam(params[name])
 			}
 		}
-		return match, params
 	}
-	return NoMatch, nil
+	slashed := matches[len(matches)-2] < matches[len(matches)-1]
+	wantSlash := len(r.pattern) > 1 && strings.HasSuffix(r.pattern, "/")
+	return params, slashed != wantSlash && path != "/"
+}
+
+// matchRequest returns whether the request passes the conditions of the route.
+func (r *route) matchRequest(req *http.Request) bool {
+	for _, condition := range r.conditions {
+		if !condition(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *route) slashPolicy(slash TrailingSlashPolicy) TrailingSlashPolicy {
+	if r.ownSlash {
+		return r.trailingSlash
+	}
+	return slash
+}
+
+// unescapeParam decodes a parameter captured from an escaped path, leaving it as is if it
+// isn't validly encoded.
+func unescapeParam(val string) string {
+	if unescaped, err := url.PathUnescape(val); err == nil {
+		return unescaped
+	}
+	return val
+}
+
+// validParams returns whether the captured params conform to their declared types.
+func (r *route) validParams(params map[string]string) bool {
+	for name, typ := range r.paramTypes {
+		if val, ok := params[name]; ok && !paramTypes[typ](val) {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *route) Validate() {
@@ -100,41 +389,201 @@ func (r *route) Validate() {
 	}
 }
 
-func (r *route) Handle(c Context, res http.ResponseWriter) {
-	context := &routeContext{c, 0, r.handlers}
+// chain returns the handlers run for the route on a request with the given method: those of
+// its enclosing groups, from the outermost inwards, followed by its own.
+func (r *route) chain(method string) []Handler {
+	handlers := append(r.group.chain(method), r.before...)
+	return append(handlers, r.handlers...)
+}
+
+func (r *route) Handle(c Context, res http.ResponseWriter, req *http.Request) {
+	// a GET route answering a HEAD request only sends the headers of its response, and runs
+	// the handlers of the GET requests, ForMethods guards included
+	method := req.Method
+	handled := false
+	var head *headResponseWriter
+	if r.MatchMethod(req.Method) == OverloadMatch {
+		method = "GET"
+		if ctx, ok := c.(*context); ok {
+			head = &headResponseWriter{ResponseWriter: ctx.rw}
+			ctx.setWriter(head)
+			res = head
+			// when the handlers panic, the headers held back are sent or the middlewares
+			// recovering from the panic answer through the writer of the request
+			defer func() {
+				head.commit()
+				if !handled {
+					ctx.setWriter(head.ResponseWriter)
+				}
+			}()
+		}
+	}
+
+	// the route may be changed meanwhile, the request goes on with the settings it started with
+	unlock := r.rlock()
+	handlers, after := r.chain(method), r.after
+	returnHandler, rawBody, parseForm := r.returnHandler, r.rawBody, r.parseForm
+	timeout, defaultStatus := r.timeout, r.defaultStatus
+	unlock()
+
+	context := &routeContext{c, 0, handlers}
 	c.MapTo(context, (*Context)(nil))
 	c.MapTo(r, (*Route)(nil))
-	context.run()
+	if returnHandler != nil {
+		c.Map(returnHandler)
+	}
+	// the body is parsed before any handler runs when they need it, so that one that can't be
+	// parsed is answered without running them
+	if !rawBody && (parseForm || injectsFormParams(handlers)) && !parsesForm(c) {
+		handled = true
+		return
+	}
+	if timeout > 0 {
+		runWithTimeout(context, req, timeout, defaultStatus)
+	} else {
+		context.run()
+	}
+	if defaultStatus != 0 && !context.Written() {
+		res.WriteHeader(defaultStatus)
+	}
+	if head != nil {
+		head.commit()
+	}
+	for _, handler := range after {
+		if _, err := c.Invoke(handler); err != nil {
+			panic(err)
+		}
+	}
+	handled = true
 }
 
-var urlReg = regexp.MustCompile(`:[^/#?()\.\\]+|\(\?P<[a-zA-Z0-9]+>.*\)`)
+// urlReg matches an escaped character, a `/*name` catch-all, an optional `/?:name?` segment,
+// a `:name` parameter or a `(?P<name>...)` group. Group bodies may hold escaped characters
+// but no nested parentheses, so literal parentheses elsewhere in the pattern are never taken
+// for a parameter.
+var urlReg = regexp.MustCompile(`\\.|/\*[A-Za-z_]\w*|/\?:[^/#?()\.\\]+\?|:[^/#?()\.\\]+|\(\?P<\w+>(?:[^()\\]|\\.)*\)`)
+
+// paramCount returns the number of parameters URLWith replaces.
+func (r *route) paramCount() int {
+	n := 0
+	for _, m := range urlReg.FindAllString(r.pattern, -1) {
+		if m[0] != '\\' {
+			n++
+		}
+	}
+	return n
+}
 
 // URLWith returns the url pattern replacing the parameters for its values
 func (r *route) URLWith(args []string) string {
-	if len(args) > 0 {
-		argCount := len(args)
-		i := 0
-		url := urlReg.ReplaceAllStringFunc(r.pattern, func(m string) string {
-			var val interface{}
-			if i < argCount {
-				val = args[i]
-			} else {
-				val = m
+	return r.render(func(i int, name string) (string, bool) {
+		if i < len(args) {
+			return args[i], true
+		}
+		return "", false
+	})
+}
+
+// urlWithMap is like URLWith but takes the values of the parameters by name, also returning
+// the names of the parameters of the pattern and of those missing a value, optional segments
+// aside.
+func (r *route) urlWithMap(params map[string]string) (string, []string, []string) {
+	optional := map[string]bool{}
+	for _, m := range optionalParamReg.FindAllString(r.pattern, -1) {
+		optional[paramName(m)] = true
+	}
+	var names, missing []string
+	u := r.render(func(i int, name string) (string, bool) {
+		names = append(names, name)
+		val, ok := params[name]
+		if !ok && !optional[name] {
+			missing = append(missing, name)
+		}
+		return val, ok
+	})
+	return u, names, missing
+}
+
+// render replaces the parameters of the pattern, in order, with the values given by value,
+// which reports false for those it has none for. Such parameters are left as they are,
+// optional segments being left out instead.
+func (r *route) render(value func(i int, name string) (string, bool)) string {
+	i := 0
+	return urlReg.ReplaceAllStringFunc(r.pattern, func(m string) string {
+		if m[0] == '\\' {
+			// escaped literal, e.g. `\(`
+			return m[1:]
+		}
+		val, ok := value(i, paramName(m))
+		i += 1
+		switch {
+		case strings.HasPrefix(m, "/*"):
+			// catch-all, rendered with its value as is, slashes included
+			if ok {
+				return "/" + strings.TrimPrefix(val, "/")
+			}
+		case strings.HasPrefix(m, "/?"):
+			// optional segment, left out when no value is given for it
+			if ok && val != "" {
+				return "/" + val
 			}
-			i += 1
-			return fmt.Sprintf(`%v`, val)
-		})
+			return ""
+		case ok:
+			return val
+		}
+		return m
+	})
+}
 
-		return url
+// paramName returns the name of a parameter matched by urlReg.
+func paramName(m string) string {
+	switch {
+	case strings.HasPrefix(m, "/*"):
+		return m[2:]
+	case strings.HasPrefix(m, "/?"):
+		name, _ := splitParam(m[2 : len(m)-1])
+		return name
+	case strings.HasPrefix(m, "(?P<"):
+		return m[4:strings.IndexByte(m, '>')]
+	default:
+		name, _ := splitParam(m)
+		return name
 	}
-	return r.pattern
 }
 
-func (r *route) SetName(name string) {
+// lock takes the lock of the router the route was added to, if any, before changing the route,
+// and returns the function releasing it.
+func (r *route) lock() func() {
+	if r.router == nil {
+		return func() {}
+	}
+	r.router.mu.Lock()
+	return r.router.mu.Unlock
+}
+
+// rlock is lock for reading the route.
+func (r *route) rlock() func() {
+	if r.router == nil {
+		return func() {}
+	}
+	r.router.mu.RLock()
+	return r.router.mu.RUnlock
+}
+
+func (r *route) SetName(name string) Route {
+	defer r.lock()()
+	name = r.group.qualify(name)
+	if r.router != nil && name != "" {
+		if other := r.router.lookupRoute(name); other != nil && other != r {
+			panic(fmt.Sprintf("duplicate route name %q for %s %s and %s %s", name, other.method, other.pattern, r.method, r.pattern))
+		}
+	}
 	r.name = name
+	return r
 }
 
 func (r *route) Name() string {
+	defer r.rlock()()
 	return r.name
 }
 
@@ -146,6 +595,187 @@ func (r *route) Method() string {
 	return r.method
 }
 
+func (r *route) IsWildcardMethod() bool {
+	return r.method == "*"
+}
+
+func (r *route) Methods() []string {
+	if r.methods != nil {
+		methods := append([]string(nil), r.methods...)
+		if hasMethod(methods, "GET") && !hasMethod(methods, "HEAD") {
+			methods = append(methods, "HEAD")
+		}
+		return methods
+	}
+	switch r.method {
+	case "*":
+		return append([]string(nil), WildcardMethods...)
+	case "GET":
+		return []string{"GET", "HEAD"}
+	default:
+		return []string{r.method}
+	}
+}
+
+func (r *route) SetMeta(key string, val interface{}) Route {
+	defer r.lock()()
+	if r.meta == nil {
+		r.meta = make(map[string]interface{})
+	}
+	r.meta[key] = val
+	return r
+}
+
+func (r *route) Meta(key string) (interface{}, bool) {
+	defer r.rlock()()
+	val, ok := r.meta[key]
+	return val, ok
+}
+
+func (r *route) SetReturnHandler(handler RouterReturnHandler) Route {
+	defer r.lock()()
+	r.returnHandler = handler
+	return r
+}
+
+func (r *route) SetDefaultStatus(status int) Route {
+	defer r.lock()()
+	r.defaultStatus = status
+	return r
+}
+
+func (r *route) DefaultStatus() int {
+	defer r.rlock()()
+	return r.defaultStatus
+}
+
+func (r *route) Where(param string, expr string) Route {
+	if r.regex.SubexpIndex(param) < 0 {
+		panic(fmt.Sprintf("route %s has no parameter %q", r.pattern, param))
+	}
+	constraint := regexp.MustCompile(`^(?:` + expr + `)$`)
+	defer r.lock()()
+	if r.constraints == nil {
+		r.constraints = make(map[string]*regexp.Regexp)
+	}
+	r.constraints[param] = constraint
+	return r
+}
+
+func (r *route) SetPriority(priority int) Route {
+	defer r.lock()()
+	r.priority = priority
+	if r.router != nil {
+		r.router.resort(r)
+	}
+	return r
+}
+
+func (r *route) Priority() int {
+	defer r.rlock()()
+	return r.priority
+}
+
+func (r *route) SetTrailingSlash(policy TrailingSlashPolicy) Route {
+	defer r.lock()()
+	r.trailingSlash = policy
+	r.ownSlash = true
+	return r
+}
+
+func (r *route) Before(handlers ...Handler) Route {
+	for _, handler := range handlers {
+		ValidateHandler(handler)
+	}
+	defer r.lock()()
+	r.before = append(r.before, handlers...)
+	return r
+}
+
+func (r *route) After(handlers ...Handler) Route {
+	for _, handler := range handlers {
+		ValidateHandler(handler)
+	}
+	defer r.lock()()
+	r.after = append(r.after, handlers...)
+	return r
+}
+
+func (r *route) Query(name string, value string) Route {
+	return r.MatchFunc(func(req *http.Request) bool {
+		values, ok := req.URL.Query()[name]
+		if !ok || value == "" {
+			return ok
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *route) Header(name string, value string) Route {
+	return r.MatchFunc(func(req *http.Request) bool {
+		values := req.Header.Values(name)
+		if value == "" {
+			return len(values) > 0
+		}
+		for _, v := range values {
+			if i := strings.IndexByte(v, ';'); i >= 0 {
+				v = v[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *route) MatchFunc(match func(*http.Request) bool) Route {
+	defer r.lock()()
+	r.conditions = append(r.conditions, match)
+	// the routes with more conditions are tried first
+	if r.router != nil {
+		r.router.resort(r)
+	}
+	return r
+}
+
+func (r *route) SetStrictSlash(strict bool) Route {
+	if strict {
+		return r.SetTrailingSlash(TrailingSlashStrict)
+	}
+	defer r.lock()()
+	r.trailingSlash, r.ownSlash = TrailingSlashPermissive, false
+	return r
+}
+
+func (r *route) SetRawBody(raw bool) Route {
+	defer r.lock()()
+	r.rawBody = raw
+	return r
+}
+
+func (r *route) RawBody() bool {
+	defer r.rlock()()
+	return r.rawBody
+}
+
+func (r *route) SetParseForm(parse bool) Route {
+	defer r.lock()()
+	r.parseForm = parse
+	return r
+}
+
+func (r *route) SetRawParams(raw bool) Route {
+	defer r.lock()()
+	r.rawParams = raw
+	return r
+}
+
 type routeContext struct {
 	Context
 	index    int
@@ -159,6 +789,10 @@ func (r *routeContext) Next() {
 
 func (r *routeContext) run() {
 	for r.index < len(r.handlers) {
+		// nobody is waiting for the response anymore
+		if r.IsCanceled() {
+			return
+		}
 		handler := r.handlers[r.index]
 		vals, err := r.Invoke(handler)
 		if err != nil {
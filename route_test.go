@@ -0,0 +1,92 @@
+package yawf
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRouteMetaSkipsAuth(t *testing.T) {
+	// the route is matched by the time the middlewares of its groups run
+	requireAuth := func(c Context) {
+		if public, _ := c.Route().Meta("public"); public != true {
+			auth(c)
+		}
+	}
+	s := newTestServer()
+	s.Group("", func(r Router) {
+		r.Get("/private", func() string { return "private" })
+		r.Get("/public", func() string { return "public" }).SetMeta("public", true)
+	}, requireAuth)
+
+	if rec := serve(s, "GET", "/private"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /private: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := serve(s, "GET", "/public"); rec.Code != http.StatusOK || rec.Body.String() != "public" {
+		t.Errorf("GET /public: got %d %q, want the public route", rec.Code, rec.Body.String())
+	}
+}
+
+func TestURLWithPunctuation(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+	}{
+		{`/files/:name.:ext`, "/files/a.b"},
+		{`/posts/(?P<id>\d+)-:slug`, "/posts/a-b"},
+		{`/report\(:year\)`, "/report(a)"},
+		{`/users/:id:int/~:nick`, "/users/a/~b"},
+		// a group that isn't a parameter is left as it is
+		{`/items/:id/(edit|view)`, "/items/a/(edit|view)"},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		route := s.Get(test.pattern, func() {})
+		if url := route.URLWith([]string{"a", "b"}); url != test.url {
+			t.Errorf("%s: got %q, want %q", test.pattern, url, test.url)
+		}
+	}
+}
+
+func TestSetDefaultStatus(t *testing.T) {
+	s := newTestServer()
+	s.Delete("/items/:id", func() {}).SetDefaultStatus(http.StatusNoContent)
+	s.Delete("/jobs/:id", func(res http.ResponseWriter) {
+		res.WriteHeader(http.StatusAccepted)
+	}).SetDefaultStatus(http.StatusNoContent)
+	s.Delete("/files/:id", func() {})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/items/1", http.StatusNoContent},
+		// the status written by the handler wins
+		{"/jobs/1", http.StatusAccepted},
+		{"/files/1", http.StatusOK},
+	}
+	for _, test := range tests {
+		if rec := serve(s, "DELETE", test.path); rec.Code != test.status {
+			t.Errorf("DELETE %s: got status %d, want %d", test.path, rec.Code, test.status)
+		}
+	}
+}
+
+func TestSettersChain(t *testing.T) {
+	s := newTestServer()
+	var returned []reflect.Value
+	rt := s.Post("/items", func() string { return "created" }).
+		SetMeta("audit", true).
+		SetDefaultStatus(http.StatusCreated).
+		SetRawBody(true).
+		SetReturnHandler(func(c Context, vals []reflect.Value) { returned = vals }).
+		SetName("items.create")
+
+	if audit, _ := rt.Meta("audit"); audit != true || rt.DefaultStatus() != http.StatusCreated || !rt.RawBody() || rt.Name() != "items.create" {
+		t.Errorf("got meta %v, status %d, raw body %t and name %q", audit, rt.DefaultStatus(), rt.RawBody(), rt.Name())
+	}
+	rec := serve(s, "POST", "/items")
+	if len(returned) != 1 || returned[0].String() != "created" || rec.Body.Len() != 0 {
+		t.Errorf("the return handler got %v, and %q was written", returned, rec.Body.String())
+	}
+}
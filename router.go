@@ -1,8 +1,16 @@
 package yawf
 
 import (
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // Params is a map of name/value pairs for named routes. An instance of yawf.Params is available to be injected into any route handler.
@@ -11,19 +19,73 @@ type PathParams map[string]string
 // Routes is a helper service for Yawf's routing layer.
 type Routes interface {
 	// URLFor returns a rendered URL for the given route. Optional params can be passed to fulfill named parameters in the route.
+	// Params beyond the parameters of the route are name/value pairs appended as the query string,
+	// along with the values of url.Values or QueryParams params, and a Fragment param sets the fragment.
 	URLFor(name string, params ...interface{}) string
-	// MethodsFor returns an array of methods available for the path
+	// URLForMap is like URLFor but takes the params by name, those that aren't parameters of the
+	// route making up the query string. Rather than panicking or leaving placeholders in the URL,
+	// it returns an error for an unknown route, an invalid param or a missing parameter, the
+	// `/?:name?` optional segments aside.
+	URLForMap(name string, params map[string]interface{}) (string, error)
+	// MethodsFor returns an array of methods available for the path. Wildcard routes contribute
+	// every method of WildcardMethods and GET routes contribute HEAD as well.
 	MethodsFor(path string) []string
+	// RoutesByPath returns the routes whose pattern matches the path, whatever their method.
+	RoutesByPath(path string) []Route
 	// All returns an array with all the routes in the router.
 	All() []Route
+	// Export describes all the routes in the router, in the order they were added.
+	Export() []RouteInfo
+	// Print writes a table of all the routes in the router, one per line with its method,
+	// pattern, name and handlers, e.g. to log it at startup.
+	Print(io.Writer)
 }
 
 // Router is Yawf's de-facto routing interface. Supports HTTP verbs, stacked handlers, and dependency injection.
 type Router interface {
 	Routes
 
-	// Group adds a group where related routes can be added.
+	// Group adds a group where related routes can be added. The given handlers run before the
+	// handlers of every route in the group.
 	Group(string, func(Router), ...Handler)
+	// GroupNamed is like Group, except that the names of the routes within are prefixed with
+	// the name of the group and a dot, after the names of the enclosing named groups, e.g. a
+	// route named "show" in a group "users" within a group "admin" is named "admin.users.show"
+	// for URLFor.
+	GroupNamed(string, string, func(Router), ...Handler)
+	// Host is like Group, except that the routes added within only match requests for the
+	// given host rather than under a path prefix. Hosts may have `:name` parameters, e.g.
+	// ":tenant.example.com", whose values end up in the PathParams along with the ones of
+	// the path. The port of the request is ignored and so is the case of the host.
+	Host(string, func(Router), ...Handler)
+	// Version is like Group with the version as prefix, e.g. "v2" for "/v2". The routes within
+	// also match the requests without the prefix whose Accept header names the version in a
+	// vendor media type, e.g. "application/vnd.myapp.v2+json", and, unless another route matches
+	// them, the requests without the prefix nor a version in the Accept header if the version
+	// is the latest one, "v10" being later than "v2".
+	Version(string, func(Router), ...Handler)
+	// GroupUse adds handlers to the group currently being defined, or to every route of the
+	// router when called outside of a group. They apply to all routes of the group, whether
+	// registered before or after the call, and also run for unmatched requests under the
+	// group's prefix before the NotFound handlers.
+	GroupUse(...Handler)
+	// ForMethods is like GroupUse, except that the handlers only run for requests whose method is
+	// one of the given ones, e.g. to guard the write methods of a group with an authorization check.
+	// Methods are case insensitive, and the HEAD requests answered by GET routes count as GET.
+	ForMethods([]string, ...Handler)
+	// GroupNotFound sets the handlers that are called instead of the NotFound and NotFoundFor ones
+	// when no route matches a request under the prefix of the group currently being defined. It
+	// is the same as NotFound when called outside of a group.
+	GroupNotFound(...Handler)
+	// Fallback adds a route for any method and any path under the prefix of the group currently
+	// being defined, or any path at all outside of a group, that only matches the requests no
+	// other route does, e.g. to serve the index page of a single page application. Requests
+	// that would otherwise get a 405 are matched as well.
+	Fallback(...Handler) Route
+	// GroupErrorHandler sets the ErrorHandler used instead of the server's one for the requests
+	// under the prefix of the group currently being defined, matched or not. It is the same as
+	// the server's SetErrorHandler when called outside of a group.
+	GroupErrorHandler(ErrorHandler)
 	// Get adds a route for a HTTP GET request to the specified matching pattern.
 	Get(string, ...Handler) Route
 	// Patch adds a route for a HTTP PATCH request to the specified matching pattern.
@@ -38,63 +100,438 @@ type Router interface {
 	Options(string, ...Handler) Route
 	// Head adds a route for a HTTP HEAD request to the specified matching pattern.
 	Head(string, ...Handler) Route
+	// Connect adds a route for a HTTP CONNECT request to the specified matching pattern.
+	Connect(string, ...Handler) Route
+	// Trace adds a route for a HTTP TRACE request to the specified matching pattern.
+	Trace(string, ...Handler) Route
+	// Match adds a single route for several HTTP methods to the specified matching pattern.
+	Match([]string, string, ...Handler) Route
 	// Any adds a route for any HTTP method request to the specified matching pattern.
 	Any(string, ...Handler) Route
 	// AddRoute adds a route for a given HTTP method request to the specified matching pattern.
+	// The method may be any valid token, e.g. WebDAV's PROPFIND, and is upper-cased.
 	AddRoute(string, string, ...Handler) Route
+	// Redirect adds a route for any method redirecting the requests matching the pattern to the
+	// given URL with the given status, e.g. http.StatusMovedPermanently. The query string of the
+	// request is kept unless the URL has one.
+	Redirect(string, string, int) Route
+	// RedirectPattern is like Redirect, except that the parameters of the target pattern are
+	// replaced by the ones of the same name of the request, e.g. from "/old/:id" to "/new/:id".
+	// It panics if the target has a parameter the pattern doesn't.
+	RedirectPattern(string, string, int) Route
+	// WebSocket adds a GET route upgrading the requests to WebSocket connections, which are
+	// injected into the handlers as a *WebSocketConn.
+	WebSocket(string, ...Handler) Route
+	// Static adds a GET route serving the files of the directory under the prefix, see StaticOptions.
+	Static(string, string, ...StaticOptions) Route
+	// File adds a GET route serving the file as a download, honoring Range requests.
+	File(string, string) Route
+	// Mount adds a route for any method and any path under the prefix that delegates to the
+	// http.Handler, with the prefix stripped from the path of the request it is given.
+	Mount(string, http.Handler) Route
+	// MountRouter moves the routes and groups of a router built on its own, e.g. in another
+	// package, under the prefix, in a group with the given handlers. Route names are kept, and
+	// the NotFound and NotFoundFor handlers of the router apply under the prefix. The mounted
+	// router must not be used afterwards.
+	MountRouter(string, Router, ...Handler)
+	// AddRouteE is like AddRoute but returns an error instead of panicking for an invalid route,
+	// and for a route conflicting with one already added, both answering to a same request.
+	AddRouteE(string, string, ...Handler) (Route, error)
+	// AddRoutes adds a route for each of the specs. Rather than panicking, it returns an error
+	// if any spec is invalid, in which case none of the routes is added.
+	AddRoutes([]RouteSpec) ([]Route, error)
+	// Remove unregisters a route, which stops matching requests. Routes may be added and
+	// removed while the router serves requests.
+	Remove(Route)
 
-	// NotFound sets the handlers that are called when a no route matches a request. Throws a basic 404 by default.
+	// NotFound sets the handlers that are called when a no route matches a request. Throws a 404 through
+	// the ErrorHandler by default.
 	NotFound(...Handler)
+	// MethodNotAllowed sets the handlers that are called when routes match the path of a request but
+	// not its method. The Allow header is set beforehand. Throws a 405 through the ErrorHandler by default.
+	MethodNotAllowed(...Handler)
+	// SetAutoOptions enables or disables answering OPTIONS requests on paths that have routes but no
+	// OPTIONS one with a 204 listing the allowed methods in the Allow header. Disabled by default.
+	SetAutoOptions(bool)
+	// SetTrailingSlash sets how routes treat a request path whose trailing slash differs from
+	// their pattern. Routes may override it with Route.SetTrailingSlash. TrailingSlashPermissive by default.
+	SetTrailingSlash(TrailingSlashPolicy)
+	// SetCleanPath sets what becomes of the requests whose path has empty, "." or ".." segments,
+	// e.g. "/a//b/../c". CleanPathOff by default.
+	SetCleanPath(CleanPathPolicy)
+	// NotFoundFor sets the handlers that are called instead of the NotFound ones when no route matches a
+	// request whose path is under the given prefix, e.g. "/api". The longest matching prefix wins.
+	NotFoundFor(string, ...Handler)
 
 	// Handle is the entry point for routing. This is used as a yawf.Handler
+	//
+	// Routes are matched against the escaped path of the request, and each captured parameter
+	// is unescaped on its own: a parameter never spans several segments, even when it
+	// contains an encoded slash, so `/files/a%2Fb` matches `/files/:name` with name "a/b".
+	// Non-ASCII characters of patterns are matched against their percent-encoded form.
+	//
+	// Of the routes matching a request, an exact match of the method is preferred to a GET
+	// route answering a HEAD request, which is preferred to a route added with Any. Ties are
+	// broken by priority, then specificity, then registration order, see Route.SetPriority.
+	//
+	// For a matched route, handlers run in this order: the server's Use handlers, then the
+	// handlers of each enclosing group from the outermost inwards, then the route's own.
 	Handle(http.ResponseWriter, *http.Request, Context)
 }
 
+// Fragment is the fragment of a URL rendered by URLFor, without its leading "#".
+type Fragment string
+
+// RouteSpec describes a route to be added with AddRoutes.
+type RouteSpec struct {
+	Method   string
+	Pattern  string
+	Name     string
+	Handlers []Handler
+}
+
 type group struct {
+	// pattern is the full prefix of the group, including the ones of its parents.
 	pattern  string
-	handlers []Handler
+	handlers []groupHandler
+	parent   *group
+	depth    int
+	regex    *regexp.Regexp
+	// host matches the hosts the group is restricted to, if any.
+	host *regexp.Regexp
+	// name is the dotted name of the group and its named parents, if any.
+	name string
+	// notFounds and errorHandler are set by GroupNotFound and GroupErrorHandler.
+	notFounds    []Handler
+	errorHandler ErrorHandler
+}
+
+// groupHandler is a handler of a group, restricted to some methods when methods isn't nil.
+type groupHandler struct {
+	handler Handler
+	methods []string
+}
+
+func newGroup(parent *group, pattern string, handlers []Handler) *group {
+	g := &group{pattern: pattern, parent: parent}
+	g.use(nil, handlers)
+	if parent != nil {
+		g.pattern = joinPattern(parent.pattern, pattern)
+		g.depth = parent.depth + 1
+		g.host = parent.host
+		g.name = parent.name
+	}
+	g.compile()
+	return g
+}
+
+// compile compiles the regexp matching the paths under the prefix of the group.
+func (g *group) compile() {
+	g.regex = regexp.MustCompile(`^` + compilePattern(escapeNonASCII(g.pattern)) + `(?:/|$)`)
+}
+
+// notFoundHandlers returns the GroupNotFound handlers of the group or of its nearest parent having some.
+func (g *group) notFoundHandlers() []Handler {
+	for ; g != nil; g = g.parent {
+		if g.notFounds != nil {
+			return g.notFounds
+		}
+	}
+	return nil
+}
+
+// mapErrorHandler maps the GroupErrorHandler of the group or of its nearest parent having one.
+func (g *group) mapErrorHandler(c Context) {
+	for ; g != nil; g = g.parent {
+		if g.errorHandler != nil {
+			c.Map(g.errorHandler)
+			return
+		}
+	}
+}
+
+// qualify prefixes a route name with the name of the group, if any.
+func (g *group) qualify(name string) string {
+	if g == nil || g.name == "" || name == "" {
+		return name
+	}
+	return g.name + "." + name
+}
+
+// compileHost converts a host pattern into a regexp, its `:name` parameters standing for a
+// single label of the host.
+func compileHost(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?i)^`)
+	last := 0
+	for _, loc := range routeReg1.FindAllStringIndex(pattern, -1) {
+		name, _ := splitParam(pattern[loc[0]:loc[1]])
+		b.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		fmt.Fprintf(&b, `(?P<%s>[^.]+)`, name)
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(pattern[last:]) + `$`)
+	return regexp.MustCompile(b.String())
+}
+
+// matchHost returns whether the group accepts the host, along with the values of the
+// parameters of its host pattern.
+func (g *group) matchHost(host string) (bool, map[string]string) {
+	if g == nil || g.host == nil {
+		return true, nil
+	}
+	matches := g.host.FindStringSubmatch(host)
+	if matches == nil {
+		return false, nil
+	}
+	params := make(map[string]string)
+	for i, name := range g.host.SubexpNames() {
+		if len(name) > 0 {
+			params[name] = matches[i]
+		}
+	}
+	return true, params
+}
+
+// requestHost returns the host of the request without its port.
+func requestHost(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.Host); err == nil {
+		return host
+	}
+	return req.Host
+}
+
+// subtreePattern matches the rest of any path under a prefix, if any.
+const subtreePattern = `(?:/[^#?]*)?`
+
+// joinPattern appends a pattern to a group prefix, making sure exactly one slash separates
+// them whether the prefix ends with one, the pattern starts with one, both or neither.
+func joinPattern(prefix string, pattern string) string {
+	if prefix == "" || pattern == "" {
+		return prefix + pattern
+	}
+	return strings.TrimRight(prefix, "/") + "/" + strings.TrimLeft(pattern, "/")
+}
+
+func (g *group) use(methods []string, handlers []Handler) {
+	if methods != nil {
+		upper := make([]string, len(methods))
+		for i, method := range methods {
+			upper[i] = strings.ToUpper(method)
+		}
+		methods = upper
+	}
+	for _, handler := range handlers {
+		ValidateHandler(handler)
+		g.handlers = append(g.handlers, groupHandler{handler, methods})
+	}
+}
+
+// chain returns the handlers of the group and of its parents that apply to the method, from
+// the outermost inwards.
+func (g *group) chain(method string) []Handler {
+	if g == nil {
+		return nil
+	}
+	handlers := g.parent.chain(method)
+	for _, h := range g.handlers {
+		if h.methods == nil || hasMethod(h.methods, method) {
+			handlers = append(handlers, h.handler)
+		}
+	}
+	return handlers
 }
 
 type router struct {
-	routes    []*route
+	// mu guards the routes, the groups and the settings of the router, as well as the fields of
+	// its routes and groups, which may all change while requests are handled. Requests are
+	// matched under the read lock, which is released before any handler runs.
+	mu     sync.RWMutex
+	routes []*route
+	// tree indexes the routes with simple patterns, complex holds the others.
+	tree    *node
+	complex []*route
+	// fallbacks are the routes added with Fallback, only tried when no other route matches.
+	fallbacks []*route
+	seq       int
+	// notFounds are the NotFound handlers, nil for the default one.
 	notFounds []Handler
-	groups    []group
+
+	methodNotAlloweds []Handler
+	autoOptions       bool
+	trailingSlash     TrailingSlashPolicy
+	cleanPath         CleanPathPolicy
+	// prefixNotFounds are the NotFoundFor handlers by path prefix.
+	prefixNotFounds map[string][]Handler
+	// current is the group routes are being added to, groups holds every group ever defined.
+	current *group
+	groups  []*group
+	// versions are the versions added with Version.
+	versions []apiVersion
 }
 
 func NewRouter() Router {
-	return &router{notFounds: []Handler{http.NotFound}, groups: make([]group, 0)}
+	root := newGroup(nil, "", nil)
+	return &router{
+		tree:              &node{},
+		methodNotAlloweds: []Handler{methodNotAllowed},
+		current:           root,
+		groups:            []*group{root},
+	}
 }
 
 func (r *router) addRoute(method string, pattern string, handlers []Handler) *route {
-	if len(r.groups) > 0 {
-		groupPattern := ""
-		h := make([]Handler, 0)
-		for _, g := range r.groups {
-			groupPattern += g.pattern
-			h = append(h, g.handlers...)
-		}
-
-		pattern = groupPattern + pattern
-		h = append(h, handlers...)
-		handlers = h
+	method, err := normalizeMethod(method)
+	if err != nil {
+		panic(err.Error())
 	}
-
-	route := newRoute(method, pattern, handlers)
+	current := r.currentGroup()
+	route := newRoute(method, joinPattern(current.pattern, pattern), handlers)
+	route.raw = pattern
+	route.group = current
 	route.Validate()
 	r.appendRoute(route)
 	return route
 }
 
+// currentGroup returns the group routes are being added to.
+func (r *router) currentGroup() *group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// buildRoute creates a route in the current group without registering it, reporting an
+// invalid method, pattern or handler as an error instead of panicking.
+func (r *router) buildRoute(method string, pattern string, handlers []Handler) (rt *route, err error) {
+	if method, err = normalizeMethod(method); err != nil {
+		return nil, err
+	}
+	for _, handler := range handlers {
+		if err := validateHandler(handler); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			rt, err = nil, fmt.Errorf("%v", e)
+		}
+	}()
+	current := r.currentGroup()
+	rt = newRoute(method, joinPattern(current.pattern, pattern), handlers)
+	rt.raw = pattern
+	rt.group = current
+	return rt, nil
+}
+
+// AddRoutes registers a route for each spec, all at once: if any spec is invalid, none of
+// them is registered and the error is returned.
+func (r *router) AddRoutes(specs []RouteSpec) ([]Route, error) {
+	built := make([]*route, len(specs))
+	for i, spec := range specs {
+		rt, err := r.buildRoute(spec.Method, spec.Pattern, spec.Handlers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route spec %d (%s %s): %v", i, spec.Method, spec.Pattern, err)
+		}
+		rt.SetName(spec.Name)
+		built[i] = rt
+	}
+
+	if i, err := r.appendRoutes(built); err != nil {
+		return nil, fmt.Errorf("invalid route spec %d (%s %s): %v", i, specs[i].Method, specs[i].Pattern, err)
+	}
+	routes := make([]Route, len(built))
+	for i, rt := range built {
+		routes[i] = rt
+	}
+	return routes, nil
+}
+
 func (r *router) appendRoute(rt *route) {
+	if _, err := r.appendRoutes([]*route{rt}); err != nil {
+		panic(err.Error())
+	}
+}
+
+// appendRoutes registers the routes under a single lock, so that requests see either all of
+// them or none. If the name of one of them is taken, by a route of the router or by one of
+// the previous ones, none is registered and its index is returned along with the error.
+func (r *router) appendRoutes(rts []*route) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rt := range rts {
+		if err := r.checkName(rt, rts[:i]); err != nil {
+			return i, err
+		}
+	}
+	for _, rt := range rts {
+		r.appendLocked(rt)
+	}
+	return 0, nil
+}
+
+// appendLocked registers the route, the lock being held.
+func (r *router) appendLocked(rt *route) {
+	r.seq++
+	rt.router = r
+	rt.seq = r.seq
 	r.routes = append(r.routes, rt)
+	if rt.fallback {
+		r.fallbacks = insertSorted(r.fallbacks, rt)
+	} else if segments, ok := treeSegments(rt.pattern); ok {
+		r.tree.insert(segments, rt)
+	} else {
+		r.complex = insertSorted(r.complex, rt)
+	}
+}
+
+// Remove filters the route out into new slices rather than in place, so that the slices
+// handed out before, e.g. by All, stay as they were.
+func (r *router) Remove(rt Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = withoutRoute(r.routes, rt)
+	r.complex = withoutRoute(r.complex, rt)
+	r.fallbacks = withoutRoute(r.fallbacks, rt)
+	if target, ok := rt.(*route); ok {
+		if segments, ok := treeSegments(target.pattern); ok {
+			r.tree.remove(segments, target)
+		}
+	}
+}
+
+func withoutRoute(routes []*route, rt Route) []*route {
+	kept := make([]*route, 0, len(routes))
+	for _, route := range routes {
+		if Route(route) != rt {
+			kept = append(kept, route)
+		}
+	}
+	return kept
 }
 
 func (r *router) getRoutes() []*route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.routes
 }
 
+func (r *router) addGroup(g *group) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups = append(r.groups, g)
+}
+
 func (r *router) findRoute(name string) *route {
-	for _, route := range r.getRoutes() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookupRoute(name)
+}
+
+// lookupRoute is findRoute with the lock held.
+func (r *router) lookupRoute(name string) *route {
+	for _, route := range r.routes {
 		if route.name == name {
 			return route
 		}
@@ -103,6 +540,20 @@ func (r *router) findRoute(name string) *route {
 	return nil
 }
 
+// checkName returns an error if a route of the router or one of the others has the name of
+// the route. The lock must be held.
+func (r *router) checkName(rt *route, others []*route) error {
+	if rt.name == "" {
+		return nil
+	}
+	for _, other := range append(append([]*route(nil), others...), r.routes...) {
+		if other.name == rt.name {
+			return fmt.Errorf("duplicate route name %q", rt.name)
+		}
+	}
+	return nil
+}
+
 // URLFor returns the url for the given route name.
 func (r *router) URLFor(name string, params ...interface{}) string {
 	route := r.findRoute(name)
@@ -112,12 +563,20 @@ func (r *router) URLFor(name string, params ...interface{}) string {
 	}
 
 	var args []string
+	var values url.Values
+	var fragment string
 	for _, param := range params {
 		switch v := param.(type) {
 		case int:
 			args = append(args, strconv.FormatInt(int64(v), 10))
 		case string:
 			args = append(args, v)
+		case Fragment:
+			fragment = string(v)
+		case url.Values:
+			values = mergeValues(values, v)
+		case QueryParams:
+			values = mergeValues(values, url.Values(v))
 		default:
 			if v != nil {
 				panic("Arguments passed to URLFor must be integers or strings")
@@ -125,7 +584,74 @@ func (r *router) URLFor(name string, params ...interface{}) string {
 		}
 	}
 
-	return route.URLWith(args)
+	var query []string
+	if n := route.paramCount(); len(args) > n {
+		extra := args[n:]
+		if len(extra)%2 != 0 {
+			panic("Query parameters passed to URLFor must be name/value pairs")
+		}
+		for i := 0; i < len(extra); i += 2 {
+			query = append(query, url.QueryEscape(extra[i])+"="+url.QueryEscape(extra[i+1]))
+		}
+		args = args[:n]
+	}
+	if len(values) > 0 {
+		query = append(query, values.Encode())
+	}
+
+	u := route.URLWith(args)
+	if len(query) > 0 {
+		u += "?" + strings.Join(query, "&")
+	}
+	if fragment != "" {
+		u += "#" + (&url.URL{Fragment: fragment}).EscapedFragment()
+	}
+	return u
+}
+
+func (r *router) URLForMap(name string, params map[string]interface{}) (string, error) {
+	route := r.findRoute(name)
+	if route == nil {
+		return "", fmt.Errorf("route %q not found", name)
+	}
+
+	args := make(map[string]string, len(params))
+	for key, param := range params {
+		switch v := param.(type) {
+		case int:
+			args[key] = strconv.FormatInt(int64(v), 10)
+		case string:
+			args[key] = v
+		default:
+			return "", fmt.Errorf("param %q of route %q must be an integer or a string", key, name)
+		}
+	}
+
+	u, names, missing := route.urlWithMap(args)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing parameter %q for route %q", missing[0], name)
+	}
+	query := url.Values{}
+	for key, val := range args {
+		query.Set(key, val)
+	}
+	for _, n := range names {
+		query.Del(n)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+func mergeValues(values url.Values, from url.Values) url.Values {
+	if values == nil {
+		values = url.Values{}
+	}
+	for name, vals := range from {
+		values[name] = append(values[name], vals...)
+	}
+	return values
 }
 
 func (r *router) All() []Route {
@@ -141,16 +667,51 @@ func (r *router) All() []Route {
 
 // MethodsFor returns all methods available for path
 func (r *router) MethodsFor(path string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return methodsOf(r.routesByPath(path))
+}
+
+func methodsOf(routes []*route) []string {
 	methods := []string{}
-	for _, route := range r.getRoutes() {
-		matches := route.regex.FindStringSubmatch(path)
-		if len(matches) > 0 && matches[0] == path && !hasMethod(methods, route.method) {
-			methods = append(methods, route.method)
+	for _, route := range routes {
+		for _, method := range route.Methods() {
+			if !hasMethod(methods, method) {
+				methods = append(methods, method)
+			}
 		}
 	}
 	return methods
 }
 
+func (r *router) RoutesByPath(path string) []Route {
+	r.mu.RLock()
+	routes := r.routesByPath(path)
+	r.mu.RUnlock()
+	var ri = make([]Route, len(routes))
+
+	for i, route := range routes {
+		ri[i] = Route(route)
+	}
+
+	return ri
+}
+
+// routesByPath returns the routes matching the escaped path, whatever the method. The lock
+// must be held.
+func (r *router) routesByPath(path string) []*route {
+	var routes []*route
+	for _, candidates := range [][]*route{r.candidates(path), r.fallbacks} {
+		for _, route := range candidates {
+			params, slashMismatch := route.matchPath(path)
+			if params != nil && (!slashMismatch || route.slashPolicy(r.trailingSlash) == TrailingSlashPermissive) {
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes
+}
+
 func hasMethod(methods []string, method string) bool {
 	for _, v := range methods {
 		if v == method {
@@ -161,11 +722,128 @@ func hasMethod(methods []string, method string) bool {
 }
 
 func (r *router) Handle(res http.ResponseWriter, req *http.Request, context Context) {
+	context.MapTo(r, (*Routes)(nil))
+	path := req.URL.EscapedPath()
+	r.mu.RLock()
+	cleanPolicy := r.cleanPath
+	r.mu.RUnlock()
+	if cleanPolicy != CleanPathOff {
+		if cleaned := cleanPath(path); cleaned != path {
+			if cleanPolicy == CleanPathRedirect {
+				redirectPath(res, req, cleaned)
+				return
+			}
+			path = cleaned
+		}
+	}
+	host := requestHost(req)
+
+	// the router is only read under the lock, which is released before running any handler
+	// since handlers may add routes
+	r.mu.RLock()
+	var bestMatch RouteMatch
+	var bestVals map[string]string
+	var bestRoute *route
+	for _, p := range r.versionPaths(req.Header.Get("Accept"), path) {
+		if bestMatch, bestVals, bestRoute = r.match(req, host, p); bestMatch != NoMatch {
+			break
+		}
+	}
+	if bestRoute != nil {
+		bestRoute.group.mapErrorHandler(context)
+	}
+	r.mu.RUnlock()
+
+	if bestMatch == RedirectMatch {
+		redirectSlash(res, req, path, bestRoute.pattern)
+		return
+	}
+	if bestMatch == ParamMismatch {
+		err := fmt.Errorf("invalid parameters for route %s %s", bestRoute.method, bestRoute.pattern)
+		respondError(context, http.StatusBadRequest, err)
+		return
+	}
+	if bestMatch != NoMatch {
+		params := PathParams(bestVals)
+		context.Map(params)
+
+		bestRoute.Handle(context, res, req)
+		return
+	}
+
+	r.mu.RLock()
+	handlers := r.unmatched(res, req, host, path, context)
+	r.mu.RUnlock()
+	c := &routeContext{context, 0, handlers}
+	context.MapTo(c, (*Context)(nil))
+	c.run()
+}
+
+// unmatched returns the handlers of a request no route matches: those of the innermost group
+// of its path followed by the NotFound ones, or the MethodNotAllowed ones when routes match
+// the path for other methods, whose Allow header it sets. The lock must be held.
+func (r *router) unmatched(res http.ResponseWriter, req *http.Request, host string, path string, context Context) []Handler {
+	g := r.groupFor(host, path)
+	g.mapErrorHandler(context)
+	fallbacks := g.notFoundHandlers()
+	if fallbacks == nil {
+		fallbacks = r.notFoundsFor(path)
+	}
+	var routes []*route
+	for _, route := range r.routesByPath(path) {
+		if ok, _ := route.group.matchHost(host); ok && route.matchRequest(req) {
+			routes = append(routes, route)
+		}
+	}
+	if methods := methodsOf(routes); len(methods) > 0 {
+		if r.autoOptions && !hasMethod(methods, "OPTIONS") {
+			methods = append(methods, "OPTIONS")
+		}
+		res.Header().Set("Allow", strings.Join(methods, ", "))
+		if r.autoOptions && req.Method == "OPTIONS" {
+			fallbacks = []Handler{answerOptions}
+		} else {
+			// the path exists for other methods, 405
+			fallbacks = r.methodNotAlloweds
+		}
+	}
+
+	// no routes exist, 404
+	return append(g.chain(req.Method), fallbacks...)
+}
+
+// match returns the route best matching the request with the escaped path, along with its
+// params. The lock must be held.
+func (r *router) match(req *http.Request, host string, path string) (RouteMatch, map[string]string, *route) {
+	bestMatch, bestVals, bestRoute := r.bestOf(r.candidates(path), req, host, path)
+	if bestMatch == NoMatch {
+		// the Fallback routes don't compete with the others, even with those merely redirecting
+		// or rejecting their parameters
+		return r.bestOf(r.fallbacks, req, host, path)
+	}
+	return bestMatch, bestVals, bestRoute
+}
+
+// bestOf returns the route best matching the request among the routes, in the order they
+// are tried in.
+func (r *router) bestOf(routes []*route, req *http.Request, host string, path string) (RouteMatch, map[string]string, *route) {
 	bestMatch := NoMatch
 	var bestVals map[string]string
 	var bestRoute *route
-	for _, route := range r.getRoutes() {
-		match, vals := route.Match(req.Method, req.URL.Path)
+	for _, route := range routes {
+		ok, hostVals := route.group.matchHost(host)
+		if !ok {
+			continue
+		}
+		match, vals := route.Match(req.Method, path, r.trailingSlash)
+		if match != NoMatch && !route.matchRequest(req) {
+			continue
+		}
+		if vals != nil {
+			for name, val := range hostVals {
+				vals[name] = val
+			}
+		}
 		if match.BetterThan(bestMatch) {
 			bestMatch = match
 			bestVals = vals
@@ -175,24 +853,129 @@ func (r *router) Handle(res http.ResponseWriter, req *http.Request, context Cont
 			}
 		}
 	}
-	if bestMatch != NoMatch {
-		params := PathParams(bestVals)
-		context.Map(params)
+	return bestMatch, bestVals, bestRoute
+}
 
-		bestRoute.Handle(context, res)
-		return
+// notFoundsFor returns the NotFoundFor handlers of the longest prefix of the escaped path, or
+// the NotFound ones if no prefix matches.
+func (r *router) notFoundsFor(path string) []Handler {
+	handlers := r.notFounds
+	if handlers == nil {
+		handlers = []Handler{notFound}
+	}
+	longest := -1
+	for prefix, h := range r.prefixNotFounds {
+		if len(prefix) > longest && hasPathPrefix(path, escapeNonASCII(prefix)) {
+			handlers = h
+			longest = len(prefix)
+		}
 	}
+	return handlers
+}
 
-	// no routes exist, 404
-	c := &routeContext{context, 0, r.notFounds}
-	context.MapTo(c, (*Context)(nil))
-	c.run()
+// hasPathPrefix returns whether the path is the prefix itself or lies below it, so that
+// "/api" covers "/api" and "/api/users" but not "/apis".
+func hasPathPrefix(path string, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// groupFor returns the innermost group whose prefix matches the path and that accepts the
+// host. The lock must be held.
+func (r *router) groupFor(host string, path string) *group {
+	var best *group
+	for _, g := range r.groups {
+		if (best == nil || g.depth > best.depth) && g.regex.MatchString(path) {
+			if ok, _ := g.matchHost(host); ok {
+				best = g
+			}
+		}
+	}
+	return best
 }
 
 func (r *router) Group(pattern string, fn func(Router), h ...Handler) {
-	r.groups = append(r.groups, group{pattern, h})
+	r.within(func(parent *group) *group {
+		return newGroup(parent, pattern, h)
+	}, fn)
+}
+
+func (r *router) GroupNamed(name string, pattern string, fn func(Router), h ...Handler) {
+	r.within(func(parent *group) *group {
+		g := newGroup(parent, pattern, h)
+		g.name = parent.qualify(name)
+		return g
+	}, fn)
+}
+
+func (r *router) Host(pattern string, fn func(Router), h ...Handler) {
+	r.within(func(parent *group) *group {
+		g := newGroup(parent, "", h)
+		g.host = compileHost(pattern)
+		return g
+	}, fn)
+}
+
+// within adds the group built from the current one and makes it the current group while fn
+// adds its routes.
+func (r *router) within(build func(parent *group) *group, fn func(Router)) {
+	r.mu.Lock()
+	parent := r.current
+	r.current = build(parent)
+	r.groups = append(r.groups, r.current)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.current = parent
+		r.mu.Unlock()
+	}()
 	fn(r)
-	r.groups = r.groups[:len(r.groups)-1]
+}
+
+func (r *router) GroupUse(h ...Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.use(nil, h)
+}
+
+func (r *router) GroupNotFound(h ...Handler) {
+	if r.currentGroup().parent == nil {
+		r.NotFound(h...)
+		return
+	}
+	for _, handler := range h {
+		ValidateHandler(handler)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.notFounds = h
+}
+
+func (r *router) Fallback(h ...Handler) Route {
+	current := r.currentGroup()
+	build := func(prefix string) (string, []Handler) {
+		return strings.TrimRight(prefix, "/") + subtreePattern, h
+	}
+	pattern, _ := build(current.pattern)
+	route := newRoute("*", pattern, h)
+	route.build = build
+	route.group = current
+	route.fallback = true
+	route.Validate()
+	r.appendRoute(route)
+	return route
+}
+
+func (r *router) GroupErrorHandler(handler ErrorHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.errorHandler = handler
+}
+
+func (r *router) ForMethods(methods []string, h ...Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.use(methods, h)
 }
 
 func (r *router) Get(pattern string, h ...Handler) Route {
@@ -223,6 +1006,37 @@ func (r *router) Head(pattern string, h ...Handler) Route {
 	return r.addRoute("HEAD", pattern, h)
 }
 
+func (r *router) Connect(pattern string, h ...Handler) Route {
+	return r.addRoute("CONNECT", pattern, h)
+}
+
+func (r *router) Trace(pattern string, h ...Handler) Route {
+	return r.addRoute("TRACE", pattern, h)
+}
+
+func (r *router) Match(methods []string, pattern string, h ...Handler) Route {
+	if len(methods) == 0 {
+		panic("missing methods")
+	}
+	normalized := make([]string, len(methods))
+	for i, method := range methods {
+		m, err := normalizeMethod(method)
+		if err != nil || m == "*" {
+			panic(fmt.Sprintf("invalid method %q", method))
+		}
+		normalized[i] = m
+	}
+
+	current := r.currentGroup()
+	route := newRoute(strings.Join(normalized, ","), joinPattern(current.pattern, pattern), h)
+	route.raw = pattern
+	route.methods = normalized
+	route.group = current
+	route.Validate()
+	r.appendRoute(route)
+	return route
+}
+
 func (r *router) Any(pattern string, h ...Handler) Route {
 	return r.addRoute("*", pattern, h)
 }
@@ -232,5 +1046,99 @@ func (r *router) AddRoute(method, pattern string, h ...Handler) Route {
 }
 
 func (r *router) NotFound(handler ...Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.notFounds = handler
 }
+
+func (r *router) MethodNotAllowed(handler ...Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methodNotAlloweds = handler
+}
+
+func (r *router) SetAutoOptions(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.autoOptions = enabled
+}
+
+func (r *router) SetTrailingSlash(policy TrailingSlashPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trailingSlash = policy
+}
+
+// redirectSlash redirects the request to the escaped path with the trailing slash of the pattern.
+func redirectSlash(res http.ResponseWriter, req *http.Request, path string, pattern string) {
+	target := strings.TrimRight(path, "/")
+	if strings.HasSuffix(pattern, "/") {
+		target += "/"
+	}
+	redirectPath(res, req, target)
+}
+
+// redirectPath permanently redirects the request to the escaped path, keeping its query
+// string, with a 301 for GET and HEAD requests and a 308 otherwise.
+func redirectPath(res http.ResponseWriter, req *http.Request, path string) {
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	status := http.StatusPermanentRedirect
+	if req.Method == "GET" || req.Method == "HEAD" {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(res, req, path, status)
+}
+
+// CleanPathPolicy is what becomes of the requests whose path isn't clean, having empty, "."
+// or ".." segments, e.g. "/a//b/./c/../d" rather than "/a/b/d".
+type CleanPathPolicy int
+
+const (
+	// CleanPathOff matches the path as it is. It is the default.
+	CleanPathOff CleanPathPolicy = iota
+	// CleanPathRewrite matches the cleaned path instead.
+	CleanPathRewrite
+	// CleanPathRedirect redirects to the cleaned path, with a 301 for GET and HEAD requests
+	// and a 308 otherwise.
+	CleanPathRedirect
+)
+
+// cleanPath cleans an escaped path like path.Clean, percent-encoded dots included, but keeps
+// its trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + encodedDotReg.ReplaceAllString(p, "."))
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+var encodedDotReg = regexp.MustCompile(`%2[eE]`)
+
+func (r *router) SetCleanPath(policy CleanPathPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanPath = policy
+}
+
+// answerOptions is the handler of automatic OPTIONS responses, the Allow header being set by Handle.
+func answerOptions(res http.ResponseWriter) {
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (r *router) NotFoundFor(prefix string, handler ...Handler) {
+	for _, h := range handler {
+		ValidateHandler(h)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.prefixNotFounds == nil {
+		r.prefixNotFounds = make(map[string][]Handler)
+	}
+	r.prefixNotFounds[prefix] = handler
+}
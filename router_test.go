@@ -0,0 +1,454 @@
+package yawf
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// auth answers every request with 401, standing for an authorization check that fails.
+func auth(c Context) {
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func TestForMethods(t *testing.T) {
+	s := newTestServer()
+	s.Group("/posts", func(r Router) {
+		r.ForMethods([]string{"POST"}, auth)
+		r.Get("", func() string { return "list" })
+		r.Post("", func() string { return "created" })
+	})
+	s.Group("/drafts", func(r Router) {
+		r.ForMethods([]string{"get"}, auth)
+		r.Get("", func() string { return "list" })
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/posts", http.StatusOK},
+		{"POST", "/posts", http.StatusUnauthorized},
+		{"GET", "/drafts", http.StatusUnauthorized},
+		// a GET route answering HEAD doesn't skip the guards of GET
+		{"HEAD", "/drafts", http.StatusUnauthorized},
+		{"HEAD", "/posts", http.StatusOK},
+	}
+	for _, test := range tests {
+		if rec := serve(s, test.method, test.path); rec.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.path, rec.Code, test.status)
+		}
+	}
+}
+
+// TestConcurrentRouteChanges is meant for the race detector: routes and groups are changed
+// and added while requests are handled.
+func TestConcurrentRouteChanges(t *testing.T) {
+	s := newTestServer()
+	route := s.Get("/items/:id", func(p PathParams) string { return p["id"] })
+
+	var wg, started sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				if n == 1 {
+					started.Done()
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+				serve(s, "GET", "/items/1")
+				serve(s, "GET", "/items/1/")
+				serve(s, "POST", "/items/1")
+				serve(s, "GET", "/missing")
+			}
+		}()
+	}
+	started.Wait()
+
+	for i := 0; i < 50; i++ {
+		route.SetName(fmt.Sprintf("item%d", i))
+		route.SetPriority(i)
+		route.Where("id", `\d+`)
+		route.Query("q", "")
+		route.Header("X-Item", "")
+		route.MatchFunc(func(*http.Request) bool { return true })
+		route.Before(func() {})
+		route.After(func() {})
+		route.SetTrailingSlash(TrailingSlashPermissive)
+		route.SetDefaultStatus(http.StatusOK)
+		route.SetMeta("i", i)
+	}
+	for i := 0; i < 50; i++ {
+		s.Group(fmt.Sprintf("/g%d", i), func(r Router) {
+			r.GroupUse(func() {})
+			r.GroupNotFound(func() {})
+			r.Get("", func() string { return "" })
+		})
+		s.NotFound(func() {})
+		s.NotFoundFor(fmt.Sprintf("/n%d", i), func() {})
+		if _, err := s.AddRoutes([]RouteSpec{{Method: "GET", Pattern: fmt.Sprintf("/a%d", i), Handlers: []Handler{func() {}}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestHeadPanic(t *testing.T) {
+	s := newTestServer()
+	s.Get("/panic", func() string { panic("boom") })
+
+	for _, method := range []string{"GET", "HEAD"} {
+		if rec := serve(s, method, "/panic"); rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s /panic: got status %d, want %d", method, rec.Code, http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestFallbackRanking(t *testing.T) {
+	s := newTestServer()
+	s.SetTrailingSlash(TrailingSlashRedirect)
+	s.Get("/users/:id:int", func(p PathParams) string { return "user " + p["id"] })
+	s.Get("/about", func() string { return "about" })
+	s.Fallback(func() string { return "fallback" })
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/users/1", http.StatusOK, "user 1"},
+		{"/users/abc", http.StatusBadRequest, ""},
+		{"/about/", http.StatusMovedPermanently, ""},
+		{"/other", http.StatusOK, "fallback"},
+	}
+	for _, test := range tests {
+		rec := serve(s, "GET", test.path)
+		if rec.Code != test.status || test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %d %q", test.path, rec.Code, rec.Body.String(), test.status, test.body)
+		}
+	}
+}
+
+func TestSetPriorityAfterAdding(t *testing.T) {
+	s := newTestServer()
+	s.Get("/robots.txt", func() string { return "first" })
+	second := s.Get("/robots.txt", func() string { return "second" })
+
+	if body := serve(s, "GET", "/robots.txt").Body.String(); body != "first" {
+		t.Fatalf("got %q, want the first route", body)
+	}
+	second.SetPriority(1)
+	if body := serve(s, "GET", "/robots.txt").Body.String(); body != "second" {
+		t.Errorf("got %q after SetPriority, want the second route", body)
+	}
+	if rec := serve(s, "GET", "/robotsXtxt"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /robotsXtxt: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// BenchmarkRouter800 matches a request among 800 routes, as many as a large application has.
+func BenchmarkRouter800(b *testing.B) {
+	s := New()
+	s.SetLogger(log.New(io.Discard, "", 0))
+	for i := 0; i < 200; i++ {
+		s.Get(fmt.Sprintf("/resource%d", i), func() {})
+		s.Post(fmt.Sprintf("/resource%d", i), func() {})
+		s.Get(fmt.Sprintf("/resource%d/:id", i), func() {})
+		s.Delete(fmt.Sprintf("/resource%d/:id", i), func() {})
+	}
+	req := httptest.NewRequest("GET", "/resource150/42", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ServeTest(req)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var order []string
+	step := func(name string) Handler {
+		return func() { order = append(order, name) }
+	}
+	s := newTestServer()
+	s.Use(step("use"))
+	s.Group("/outer", func(r Router) {
+		r.Group("/inner", func(r Router) {
+			r.GroupUse(step("inner use"))
+			r.Get("/route", step("route"))
+		}, step("inner"))
+	}, step("outer"))
+
+	tests := []struct {
+		path  string
+		order string
+	}{
+		{"/outer/inner/route", "use,outer,inner,inner use,route"},
+		// the middlewares of the groups run for the requests no route matches under them too
+		{"/outer/inner/missing", "use,outer,inner,inner use"},
+	}
+	for _, test := range tests {
+		order = nil
+		serve(s, "GET", test.path)
+		if got := strings.Join(order, ","); got != test.order {
+			t.Errorf("GET %s: got order %s, want %s", test.path, got, test.order)
+		}
+	}
+}
+
+func TestGetAndAnyOnSamePattern(t *testing.T) {
+	s := newTestServer()
+	s.Get("/items", func() string { return "get" })
+	s.Any("/items", func() string { return "any" })
+
+	for method, body := range map[string]string{"GET": "get", "POST": "any", "DELETE": "any", "OPTIONS": "any"} {
+		if rec := serve(s, method, "/items"); rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Errorf("%s /items: got %d %q, want the %s route", method, rec.Code, rec.Body.String(), body)
+		}
+	}
+
+	want := strings.Join(WildcardMethods, ",")
+	if got := strings.Join(s.MethodsFor("/items"), ","); got != want {
+		t.Errorf("MethodsFor: got %s, want %s", got, want)
+	}
+	routes := s.RoutesByPath("/items")
+	if len(routes) != 2 || routes[0].IsWildcardMethod() || !routes[1].IsWildcardMethod() {
+		t.Fatalf("RoutesByPath: got %d routes, want the GET route then the wildcard one", len(routes))
+	}
+	if got := strings.Join(routes[1].Methods(), ","); got != want {
+		t.Errorf("Methods of the wildcard route: got %s, want %s", got, want)
+	}
+}
+
+func TestGroupParams(t *testing.T) {
+	s := newTestServer()
+	handler := func(p PathParams) string { return p["org"] + "/" + p["team"] + "/" + p["id"] }
+	s.Group("/orgs/:org", func(r Router) {
+		// the slashes between the prefixes and the patterns don't matter
+		r.Get("users/:id", handler)
+		r.Group("/teams/:team/", func(r Router) {
+			r.Get("/users/:id", handler)
+		})
+	})
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/orgs/acme/users/7", "acme//7"},
+		{"/orgs/acme/teams/dev/users/7", "acme/dev/7"},
+	}
+	for _, test := range tests {
+		if rec := serve(s, "GET", test.path); rec.Code != http.StatusOK || rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %q", test.path, rec.Code, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestEncodedPaths(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:name", func(p PathParams) string { return "user " + p["name"] })
+	s.Get("/café/:dish", func(p PathParams) string { return "café " + p["dish"] })
+	s.Get("/my files/:name", func(p PathParams) string { return "file " + p["name"] })
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/users/john%20doe", "user john doe"},
+		{"/users/jos%C3%A9", "user josé"},
+		{"/users/%E2%9C%93", "user ✓"},
+		// an encoded slash stays within its segment
+		{"/users/a%2Fb", "user a/b"},
+		{"/caf%C3%A9/cr%C3%AApe", "café crêpe"},
+		{"/my%20files/a%20b.txt", "file a b.txt"},
+	}
+	for _, test := range tests {
+		if rec := serve(s, "GET", test.path); rec.Code != http.StatusOK || rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %q", test.path, rec.Code, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestNotFoundFor(t *testing.T) {
+	s := newTestServer()
+	s.NotFound(func() (int, string) { return http.StatusNotFound, "index" })
+	s.NotFoundFor("/api", func() (int, string) { return http.StatusNotFound, "api" })
+	s.NotFoundFor("/api/v2/", func() (int, string) { return http.StatusNotFound, "api v2" })
+	s.NotFoundFor("/docs/café", func() (int, string) { return http.StatusNotFound, "docs" })
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/api", "api"},
+		{"/api/users", "api"},
+		{"/api/v2/users", "api v2"},
+		{"/api/v2", "api v2"},
+		{"/apis", "index"},
+		{"/other", "index"},
+		// the path is escaped, the prefixes aren't
+		{"/docs/caf%C3%A9/x", "docs"},
+		{"/api%2Fv2/users", "index"},
+	}
+	for _, test := range tests {
+		if rec := serve(s, "GET", test.path); rec.Code != http.StatusNotFound || rec.Body.String() != test.body {
+			t.Errorf("GET %s: got %d %q, want %q", test.path, rec.Code, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	s := newTestServer()
+	s.Host("api.example.com", func(r Router) {
+		r.Get("/status", func() string { return "api" })
+	})
+	s.Host(":tenant.example.com", func(r Router) {
+		r.Get("/users/:id", func(p PathParams) string { return p["tenant"] + " " + p["id"] })
+	})
+	s.Host(":tenant.:region.example.org", func(r Router) {
+		r.Group("/shop", func(r Router) {
+			r.Get("/:item", func(p PathParams) string { return p["tenant"] + " " + p["region"] + " " + p["item"] })
+		})
+	})
+	s.Get("/status", func() string { return "any" })
+
+	tests := []struct {
+		host string
+		path string
+		body string
+	}{
+		{"api.example.com", "/status", "api"},
+		{"API.Example.com:8080", "/status", "api"},
+		{"www.example.com", "/status", "any"},
+		{"acme.example.com", "/users/7", "acme 7"},
+		{"acme.example.com:443", "/users/7", "acme 7"},
+		{"acme.eu.example.org", "/shop/book", "acme eu book"},
+		// a parameter stands for a single label
+		{"a.b.example.com", "/users/7", ""},
+		{"example.com", "/users/7", ""},
+		{"acme.example.com.evil.net", "/users/7", ""},
+		{"acme.example.org", "/shop/book", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Host = test.host
+		rec := s.ServeTest(req)
+		if test.body == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s%s: got %d %q, want 404", test.host, test.path, rec.Code, rec.Body.String())
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != test.body {
+			t.Errorf("%s%s: got %d %q, want %q", test.host, test.path, rec.Code, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestURLForMap(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:id/posts/?:slug?", func() {}).SetName("post")
+	s.Get("/files/*path", func() {}).SetName("file")
+	s.GroupNamed("admin", "/admin", func(r Router) {
+		r.Get("/users/:id", func() {}).SetName("user")
+	})
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		url    string
+		err    string
+	}{
+		{"post", map[string]interface{}{"id": 7}, "/users/7/posts", ""},
+		{"post", map[string]interface{}{"id": "7", "slug": "hello"}, "/users/7/posts/hello", ""},
+		{"post", map[string]interface{}{"id": 7, "q": "a b", "page": 2}, "/users/7/posts?page=2&q=a+b", ""},
+		{"file", map[string]interface{}{"path": "docs/readme.md"}, "/files/docs/readme.md", ""},
+		{"admin.user", map[string]interface{}{"id": 1}, "/admin/users/1", ""},
+		{"post", map[string]interface{}{"slug": "hello"}, "", `missing parameter "id" for route "post"`},
+		{"post", map[string]interface{}{"id": 1.5}, "", `param "id" of route "post" must be an integer or a string`},
+		{"user", map[string]interface{}{"id": 1}, "", `route "user" not found`},
+	}
+	for _, test := range tests {
+		u, err := s.URLForMap(test.name, test.params)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %v: got %q and error %v, want error %q", test.name, test.params, u, err, test.err)
+			}
+			continue
+		}
+		if err != nil || u != test.url {
+			t.Errorf("%s %v: got %q and error %v, want %q", test.name, test.params, u, err, test.url)
+		}
+	}
+
+	// the URLs lead back to their routes
+	u, _ := s.URLForMap("post", map[string]interface{}{"id": 7, "slug": "hello"})
+	if rt := s.RoutesByPath(u); len(rt) != 1 || rt[0].Name() != "post" {
+		t.Errorf("%s: got routes %v", u, rt)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	s := newTestServer()
+	routes := map[string]Route{
+		"/static":    s.Get("/static", func() string { return "static" }).SetName("static"),
+		"/users/7":   s.Get("/users/:id", func() string { return "user" }),
+		"/files/abc": s.Get(`/files/(?P<name>[a-z]+)`, func() string { return "file" }),
+		"/docs/a/b":  s.Get("/docs/**", func() string { return "docs" }),
+		"/ping":      s.Any("/ping", func() string { return "ping" }),
+	}
+	// another method on the same pattern stays, answering GET requests with 405 once the GET
+	// route is removed
+	s.Post("/users/:id", func() string { return "updated" })
+	before := s.All()
+
+	for path, rt := range routes {
+		if rec := serve(s, "GET", path); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d before the removal", path, rec.Code)
+		}
+		s.Remove(rt)
+		want := http.StatusNotFound
+		if path == "/users/7" {
+			want = http.StatusMethodNotAllowed
+		}
+		if rec := serve(s, "GET", path); rec.Code != want {
+			t.Errorf("%s: got %d %q after the removal, want %d", path, rec.Code, rec.Body.String(), want)
+		}
+	}
+	if rec := serve(s, "POST", "/users/7"); rec.Body.String() != "updated" {
+		t.Errorf("POST /users/7: got %d %q", rec.Code, rec.Body.String())
+	}
+	if all := s.All(); len(all) != 1 || all[0].Method() != "POST" {
+		t.Errorf("got %d routes left, want the POST one", len(all))
+	}
+	// the routes handed out before don't change
+	if len(before) != 6 {
+		t.Errorf("got %d routes in a slice from before the removals, want 6", len(before))
+	}
+
+	// the name and the pattern are free again
+	s.Get("/static", func() string { return "again" }).SetName("static")
+	if body := serve(s, "GET", s.URLFor("static")).Body.String(); body != "again" {
+		t.Errorf("got %q from a route added back", body)
+	}
+	// removing a route twice is harmless
+	s.Remove(routes["/static"])
+	if body := serve(s, "GET", "/static").Body.String(); body != "again" {
+		t.Errorf("got %q after removing a removed route", body)
+	}
+}
@@ -0,0 +1,63 @@
+package yawf
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func (c *context) SSEvent(name string, data interface{}) error {
+	var payload string
+	switch v := data.(type) {
+	case string:
+		payload = v
+	case []byte:
+		payload = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		payload = string(b)
+	}
+
+	var event strings.Builder
+	if name != "" {
+		event.WriteString("event: " + stripNewlines(name) + "\n")
+	}
+	// a line break in the data would end the field, each line gets its own
+	for _, line := range strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(payload), "\n") {
+		event.WriteString("data: " + line + "\n")
+	}
+	event.WriteString("\n")
+	return c.writeSSE(event.String())
+}
+
+func (c *context) SSEHeartbeat() error {
+	return c.writeSSE(": heartbeat\n\n")
+}
+
+// writeSSE writes a chunk of an event stream and flushes it, sending the headers of the stream
+// beforehand if the response hasn't been written yet.
+func (c *context) writeSSE(chunk string) error {
+	if !c.rw.Written() {
+		header := c.rw.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		// keeps nginx from buffering the stream
+		header.Set("X-Accel-Buffering", "no")
+		c.rw.WriteHeader(http.StatusOK)
+		markStreamed(c.rw)
+	}
+	if _, err := c.rw.Write([]byte(chunk)); err != nil {
+		return err
+	}
+	c.rw.Flush()
+	return nil
+}
+
+// stripNewlines removes the line breaks of a field of an event.
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,106 @@
+package yawf
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSSEvent(t *testing.T) {
+	s := newTestServer()
+	s.Get("/events", func(c Context) {
+		c.SSEvent("", "plain")
+		c.SSEvent("user", map[string]int{"id": 7})
+		c.SSEvent("bytes", []byte("raw"))
+		c.SSEHeartbeat()
+		c.SSEvent("multi\nline", "a\nb\r\nc\rd")
+	})
+
+	rec := serve(s, "GET", "/events")
+	want := "data: plain\n\n" +
+		"event: user\ndata: {\"id\":7}\n\n" +
+		"event: bytes\ndata: raw\n\n" +
+		": heartbeat\n\n" +
+		"event: multiline\ndata: a\ndata: b\ndata: c\ndata: d\n\n"
+	if rec.Body.String() != want {
+		t.Errorf("got body %q, want %q", rec.Body.String(), want)
+	}
+	headers := map[string]string{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"Connection":        "keep-alive",
+		"X-Accel-Buffering": "no",
+	}
+	for name, value := range headers {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("got %s %q, want %q", name, got, value)
+		}
+	}
+	if rec.Code != http.StatusOK || !rec.Flushed {
+		t.Errorf("got status %d, flushed %t", rec.Code, rec.Flushed)
+	}
+}
+
+func TestSSEventInvalidData(t *testing.T) {
+	s := newTestServer()
+	s.Get("/events", func(c Context) string {
+		if err := c.SSEvent("bad", make(chan int)); err == nil {
+			return "no error"
+		}
+		return "error"
+	})
+	// nothing was sent, the response is free to report the error
+	rec := serve(s, "GET", "/events")
+	if rec.Body.String() != "error" || rec.Header().Get("Content-Type") == "text/event-stream" {
+		t.Errorf("got %q as %q", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestSSEHeartbeatClientGone(t *testing.T) {
+	s := newTestServer()
+	errs := make(chan error, 1)
+	s.Get("/events", func(c Context) {
+		if err := c.SSEvent("hello", "world"); err != nil {
+			errs <- err
+			return
+		}
+		// wait for the client to go away, sending heartbeats meanwhile
+		for {
+			select {
+			case <-c.Ctx().Done():
+				errs <- c.Ctx().Err()
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if err := c.SSEHeartbeat(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bufio.NewReader(res.Body)
+	for _, want := range []string{"event: hello\n", "data: world\n", "\n", ": heartbeat\n"} {
+		if line, err := events.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("got %q and error %v, want %q", line, err, want)
+		}
+	}
+	res.Body.Close()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("no error once the client was gone")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler didn't notice the client was gone")
+	}
+}
@@ -0,0 +1,137 @@
+package yawf
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"time"
+)
+
+// StaticOptions is a struct for specifying configuration options for Static.
+type StaticOptions struct {
+	// IndexFile is the file served for a directory, "index.html" by default. Directories
+	// without one aren't listed but answered with a 404.
+	IndexFile string
+	// MaxAge sets the max-age of the Cache-Control header when not zero.
+	MaxAge time.Duration
+}
+
+func prepareStaticOptions(options []StaticOptions) StaticOptions {
+	var opt StaticOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	if opt.IndexFile == "" {
+		opt.IndexFile = "index.html"
+	}
+	return opt
+}
+
+// Static adds a GET route serving the files of the directory under the prefix, with their
+// Content-Type and Last-Modified headers, Range and conditional requests being honored. Paths
+// are cleaned before being looked up, so they can't reach outside of the directory.
+func (r *router) Static(prefix string, directory string, options ...StaticOptions) Route {
+	opt := prepareStaticOptions(options)
+	dir := http.Dir(directory)
+	return r.addRoute("GET", joinPattern(prefix, "*filepath"), []Handler{func(c Context, res http.ResponseWriter, req *http.Request, params PathParams) {
+		name := path.Clean("/" + params["filepath"])
+		f, err := dir.Open(name)
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			respondError(c, http.StatusNotFound, err)
+			return
+		}
+
+		if fi.IsDir() {
+			index, err := dir.Open(path.Join(name, opt.IndexFile))
+			if err != nil {
+				respondError(c, http.StatusNotFound, err)
+				return
+			}
+			defer index.Close()
+			if fi, err = index.Stat(); err != nil || fi.IsDir() {
+				respondError(c, http.StatusNotFound, err)
+				return
+			}
+			f = index
+		}
+
+		if opt.MaxAge > 0 {
+			res.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(opt.MaxAge.Seconds())))
+		}
+		http.ServeContent(res, req, fi.Name(), fi.ModTime(), f)
+	}})
+}
+
+// File adds a GET route serving a single file as a download named after it, Range and
+// conditional requests being honored so that downloads can be resumed.
+func (r *router) File(pattern string, filename string) Route {
+	return r.addRoute("GET", pattern, []Handler{func(c Context, res http.ResponseWriter, req *http.Request) {
+		serveFile(c, res, req, filename, true, "")
+	}})
+}
+
+func init() {
+	RegisterReturnType(reflect.TypeOf(FileResponse{}), func(ctx Context, res http.ResponseWriter, status int, v interface{}) {
+		file := v.(FileResponse)
+		serveFile(ctx, res, requestOf(ctx), file.Path, file.Attachment, file.Filename)
+	})
+}
+
+// FileResponse is a file returned by a handler, see File and Attachment. It is served as the
+// router's File does, the status returned along with it being ignored.
+type FileResponse struct {
+	Path string
+	// Attachment has the file downloaded rather than displayed, under Filename or its own
+	// name when empty.
+	Attachment bool
+	Filename   string
+}
+
+// File returns the named file for a handler to return, served with the Content-Type
+// of its extension, Range and conditional requests being honored. A missing file is answered
+// with 404 Not Found through the ErrorHandler.
+func File(name string) FileResponse {
+	return FileResponse{Path: name}
+}
+
+// Attachment is like File but has the file downloaded under the filename, or its own name
+// when empty.
+func Attachment(name string, filename string) FileResponse {
+	return FileResponse{Path: name, Attachment: true, Filename: filename}
+}
+
+// serveFile serves the named file, as an attachment named after it, or the filename when not
+// empty, if attachment is set.
+func serveFile(c Context, res http.ResponseWriter, req *http.Request, name string, attachment bool, filename string) {
+	f, err := os.Open(name)
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%s is a directory", name)
+	}
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	if attachment {
+		if filename == "" {
+			filename = fi.Name()
+		}
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	http.ServeContent(res, req, fi.Name(), fi.ModTime(), f)
+}
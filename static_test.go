@@ -0,0 +1,199 @@
+package yawf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStaticDir creates a directory to serve, next to a file that mustn't be served:
+//
+//	secret.txt
+//	public/hello.txt
+//	public/index.html
+//	public/sub/index.html
+//	public/empty/
+func newStaticDir(t *testing.T) string {
+	root := t.TempDir()
+	files := map[string]string{
+		"secret.txt":            "secret",
+		"public/hello.txt":      "hello",
+		"public/index.html":     "<p>home</p>",
+		"public/sub/index.html": "<p>sub</p>",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "public", "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestStatic(t *testing.T) {
+	root := newStaticDir(t)
+	s := newTestServer()
+	s.Static("/static", filepath.Join(root, "public"))
+
+	tests := []struct {
+		path        string
+		status      int
+		body        string
+		contentType string
+	}{
+		{"/static/hello.txt", http.StatusOK, "hello", "text/plain; charset=utf-8"},
+		{"/static/", http.StatusOK, "<p>home</p>", "text/html; charset=utf-8"},
+		{"/static/sub", http.StatusOK, "<p>sub</p>", "text/html; charset=utf-8"},
+		{"/static/sub/", http.StatusOK, "<p>sub</p>", "text/html; charset=utf-8"},
+		{"/static/empty/", http.StatusNotFound, "", ""},
+		{"/static/missing.txt", http.StatusNotFound, "", ""},
+	}
+	for _, test := range tests {
+		rec := serve(s, "GET", test.path)
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.path, rec.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if rec.Body.String() != test.body || rec.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: got %q as %q, want %q as %q", test.path, rec.Body.String(), rec.Header().Get("Content-Type"), test.body, test.contentType)
+		}
+		if rec.Header().Get("Last-Modified") == "" {
+			t.Errorf("%s: no Last-Modified header", test.path)
+		}
+		if rec.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s: got Cache-Control %q without MaxAge", test.path, rec.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestStaticTraversal(t *testing.T) {
+	root := newStaticDir(t)
+	s := newTestServer()
+	s.Static("/static", filepath.Join(root, "public"))
+
+	for _, target := range []string{
+		"/static/../secret.txt",
+		"/static/sub/../../secret.txt",
+		"/static/%2e%2e/secret.txt",
+		"/static/..%2fsecret.txt",
+		"/static/..%5csecret.txt",
+		"/static/%2e%2e%2f%2e%2e%2fsecret.txt",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path, req.URL.RawPath = "", ""
+		req.RequestURI = target
+		req.URL, _ = req.URL.Parse(target)
+		rec := s.ServeTest(req)
+		if rec.Body.String() == "secret" {
+			t.Errorf("%s: a file outside of the directory was served", target)
+		}
+		if rec.Code == http.StatusOK {
+			t.Errorf("%s: got status 200", target)
+		}
+	}
+}
+
+func TestStaticMaxAge(t *testing.T) {
+	root := newStaticDir(t)
+	s := newTestServer()
+	s.Static("/static", filepath.Join(root, "public"), StaticOptions{MaxAge: time.Hour, IndexFile: "hello.txt"})
+
+	rec := serve(s, "GET", "/static/hello.txt")
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("got Cache-Control %q", cc)
+	}
+	// the index file is the configured one
+	if rec := serve(s, "GET", "/static/"); rec.Body.String() != "hello" {
+		t.Errorf("got index %q, want hello.txt", rec.Body.String())
+	}
+	if rec := serve(s, "GET", "/static/sub/"); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a directory without hello.txt, want 404", rec.Code)
+	}
+}
+
+func TestStaticConditionalAndRange(t *testing.T) {
+	root := newStaticDir(t)
+	s := newTestServer()
+	s.Static("/static", filepath.Join(root, "public"))
+
+	modified := serve(s, "GET", "/static/hello.txt").Header().Get("Last-Modified")
+	req := httptest.NewRequest("GET", "/static/hello.txt", nil)
+	req.Header.Set("If-Modified-Since", modified)
+	if rec := s.ServeTest(req); rec.Code != http.StatusNotModified {
+		t.Errorf("conditional: got status %d, want 304", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/static/hello.txt", nil)
+	req.Header.Set("Range", "bytes=1-3")
+	if rec := s.ServeTest(req); rec.Code != http.StatusPartialContent || rec.Body.String() != "ell" {
+		t.Errorf("range: got %d %q, want 206 \"ell\"", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFile(t *testing.T) {
+	root := newStaticDir(t)
+	s := newTestServer()
+	s.File("/download", filepath.Join(root, "public", "hello.txt"))
+	s.File("/gone", filepath.Join(root, "missing.txt"))
+	s.File("/dir", filepath.Join(root, "public"))
+
+	rec := serve(s, "GET", "/download")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename=hello.txt` {
+		t.Errorf("got Content-Disposition %q", cd)
+	}
+	for _, path := range []string{"/gone", "/dir"} {
+		if rec := serve(s, "GET", path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestFileResponse(t *testing.T) {
+	root := newStaticDir(t)
+	name := filepath.Join(root, "public", "hello.txt")
+	s := newTestServer()
+	s.Get("/inline", func() FileResponse { return File(name) })
+	s.Get("/attachment", func() FileResponse { return Attachment(name, "") })
+	s.Get("/renamed", func() FileResponse { return Attachment(name, "report 2024 é.txt") })
+	s.Get("/pointer", func() *FileResponse {
+		file := Attachment(name, "greeting.txt")
+		return &file
+	})
+
+	tests := []struct {
+		path        string
+		disposition string
+	}{
+		{"/inline", ""},
+		{"/attachment", `attachment; filename=hello.txt`},
+		{"/renamed", `attachment; filename*=utf-8''report%202024%20%C3%A9.txt`},
+		{"/pointer", `attachment; filename=greeting.txt`},
+	}
+	for _, test := range tests {
+		rec := serve(s, "GET", test.path)
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+			t.Errorf("%s: got %d %q", test.path, rec.Code, rec.Body.String())
+		}
+		if cd := rec.Header().Get("Content-Disposition"); cd != test.disposition {
+			t.Errorf("%s: got Content-Disposition %q, want %q", test.path, cd, test.disposition)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q", test.path, ct)
+		}
+	}
+}
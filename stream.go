@@ -0,0 +1,106 @@
+package yawf
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+func (c *context) Stream(step func(io.Writer) bool) bool {
+	done := c.Ctx().Done()
+	for {
+		select {
+		case <-done:
+			return true
+		default:
+		}
+		more := step(c.rw)
+		c.rw.Flush()
+		if !more {
+			return false
+		}
+	}
+}
+
+// isSequence returns whether a value returned by a handler is a channel it can receive from
+// or an iterator, i.e. a func(yield func(T) bool) as ranged over by Go 1.23.
+func isSequence(val reflect.Value) bool {
+	if !val.IsValid() || val.IsZero() {
+		return false
+	}
+	t := val.Type()
+	switch t.Kind() {
+	case reflect.Chan:
+		return t.ChanDir()&reflect.RecvDir != 0
+	case reflect.Func:
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			return false
+		}
+		yield := t.In(0)
+		return yield.Kind() == reflect.Func && yield.NumIn() == 1 && yield.NumOut() == 1 && yield.Out(0).Kind() == reflect.Bool
+	}
+	return false
+}
+
+// writeSequence writes the elements of a channel or an iterator returned by a handler as a
+// JSON array, flushing after each of them so that long lists are never held in memory. It
+// stops early when the client goes away. The array ends when the channel is closed or the
+// iterator returns.
+func writeSequence(ctx Context, res http.ResponseWriter, status int, val reflect.Value) {
+	if res.Header().Get("Content-Type") == "" {
+		res.Header().Set("Content-Type", contentType("application/json"))
+	}
+	if status != 0 {
+		writeStatus(ctx, res, status)
+	}
+	done := ctx.Ctx().Done()
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	enc := json.NewEncoder(buf)
+	buf.WriteByte('[')
+	count := 0
+	yield := func(elem reflect.Value) bool {
+		if count > 0 {
+			buf.WriteByte(',')
+		}
+		count++
+		if err := enc.Encode(elem.Interface()); err != nil {
+			panic(err)
+		}
+		// Encode ends each element with a newline
+		buf.Truncate(buf.Len() - 1)
+		res.Write(buf.Bytes())
+		buf.Reset()
+		if flusher, ok := res.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	if val.Kind() == reflect.Chan {
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: val},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		}
+		for {
+			chosen, elem, ok := reflect.Select(cases)
+			if chosen == 1 || !ok || !yield(elem) {
+				break
+			}
+		}
+	} else {
+		yieldType := val.Type().In(0)
+		val.Call([]reflect.Value{reflect.MakeFunc(yieldType, func(args []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.ValueOf(yield(args[0])).Convert(yieldType.Out(0))}
+		})})
+	}
+	buf.WriteByte(']')
+	res.Write(buf.Bytes())
+}
@@ -0,0 +1,105 @@
+package yawf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	s := newTestServer()
+	next := make(chan struct{})
+	gone := make(chan bool, 1)
+	s.Get("/count", func(c Context) {
+		i := 0
+		gone <- c.Stream(func(w io.Writer) bool {
+			if i > 0 {
+				// the client reads the previous line before the next one is written
+				<-next
+			}
+			i++
+			fmt.Fprintf(w, "line %d\n", i)
+			return i < 3
+		})
+	})
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	lines := bufio.NewReader(res.Body)
+	for i := 1; i <= 3; i++ {
+		line, err := lines.ReadString('\n')
+		if err != nil || line != fmt.Sprintf("line %d\n", i) {
+			t.Fatalf("got %q and error %v, want line %d", line, err, i)
+		}
+		if i < 3 {
+			next <- struct{}{}
+		}
+	}
+	if rest, _ := io.ReadAll(lines); len(rest) > 0 {
+		t.Errorf("got %q after the last line", rest)
+	}
+	if <-gone {
+		t.Error("the client is reported gone although the stream ended")
+	}
+}
+
+func TestStreamClientGone(t *testing.T) {
+	s := newTestServer()
+	gone := make(chan bool, 1)
+	s.Get("/tail", func(c Context) {
+		gone <- c.Stream(func(w io.Writer) bool {
+			io.WriteString(w, "line\n")
+			time.Sleep(time.Millisecond)
+			return true
+		})
+	})
+	ts := httptest.NewServer(s.(http.Handler))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(res.Body).ReadString('\n'); err != nil || line != "line\n" {
+		t.Fatalf("got %q and error %v", line, err)
+	}
+	res.Body.Close()
+
+	select {
+	case g := <-gone:
+		if !g {
+			t.Error("the client isn't reported gone")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't stop once the client was gone")
+	}
+}
+
+func TestStreamRecorded(t *testing.T) {
+	s := newTestServer()
+	s.Get("/export", func(c Context) {
+		rows := []string{"id,name\n", "1,alice\n", "2,bob\n"}
+		c.Stream(func(w io.Writer) bool {
+			io.WriteString(w, rows[0])
+			rows = rows[1:]
+			return len(rows) > 0
+		})
+	})
+
+	rec := serve(s, "GET", "/export")
+	if rec.Code != http.StatusOK || rec.Body.String() != "id,name\n1,alice\n2,bob\n" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if !rec.Flushed {
+		t.Error("the response wasn't flushed")
+	}
+}
@@ -0,0 +1,23 @@
+package yawf
+
+import (
+	"net/http"
+	"net/http/httptest"
+)
+
+// NewTestContext creates a Context for the given request, wired up exactly like a live
+// request on a fresh server, along with the recorder its response is written to.
+// Handlers can then be run directly through the Context's Invoke.
+func NewTestContext(req *http.Request) (Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	s := New().(*classicYawf)
+	return s.CreateContext(rec, req), rec
+}
+
+// ServeTest runs the request through the full middleware and router chain and returns
+// the recorded response.
+func (s *yawf) ServeTest(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
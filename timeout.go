@@ -0,0 +1,98 @@
+package yawf
+
+import (
+	gocontext "context"
+	"github.com/codegangsta/inject"
+	"net/http"
+	"time"
+)
+
+// Timeout sets how long the handlers of the route may run. A zero duration, the default,
+// disables it. The context of the request still expires with the request timeout of the
+// server, if any, so the latter should be left to the longest one the routes need.
+//
+// The handlers run in their own goroutine with a request whose context is canceled after the
+// duration, and their response is buffered meanwhile. If they aren't done in time, the client
+// is answered with a 504 through the ErrorHandler and whatever they write afterwards is
+// discarded. They aren't stopped though, so long running handlers should give up once the
+// context of the request is done. A panic of the handlers is passed on to the Recovery
+// middleware as long as the timeout hasn't expired.
+func (r *route) Timeout(d time.Duration) Route {
+	defer r.lock()()
+	r.timeout = d
+	return r
+}
+
+// timeoutWriter buffers the response of handlers running with a timeout. Flushing it is left
+// to runWithTimeout, since it has to decide whether the response is sent at all.
+type timeoutWriter struct {
+	*BufferedResponseWriter
+}
+
+func (w timeoutWriter) Flush() {}
+
+// runWithTimeout runs the route's handlers in a goroutine, with a context of their own so that
+// they don't share the services mapped on the request with the goroutine answering the
+// request when they are late.
+func runWithTimeout(rc *routeContext, req *http.Request, timeout time.Duration, defaultStatus int) {
+	parent := rc.Context
+	res := parent.Get(inject.InterfaceOf((*http.ResponseWriter)(nil))).Interface().(http.ResponseWriter)
+	rw, ok := res.(ResponseWriter)
+	if !ok {
+		rw = NewResponseWriter(res)
+	}
+
+	ctx, cancel := gocontext.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	w := timeoutWriter{NewBufferedResponseWriter(rw)}
+	// the child holds a snapshot of the services of the request, which the goroutine answering
+	// it keeps mapping on the parent once the handlers are late; the form is parsed into it
+	// when the handlers ask for it, unless the parent did already
+	var child *context
+	if pc, ok := parent.(*context); ok {
+		child = pc.detach(w)
+		child.formParsed = pc.formParsed
+	} else {
+		child = &context{Injector: newInjector(), rw: w, formParsed: true}
+		child.SetParent(parent)
+		child.MapTo(w, (*http.ResponseWriter)(nil))
+	}
+	child.index = -1
+	timed := &routeContext{child, rc.index, rc.handlers}
+	child.MapTo(timed, (*Context)(nil))
+	child.setContext(ctx)
+
+	done := make(chan struct{})
+	var panicked interface{}
+	go func() {
+		defer func() {
+			panicked = recover()
+			close(done)
+		}()
+		timed.run()
+	}()
+
+	select {
+	case <-done:
+		for _, err := range child.errors {
+			parent.Error(err)
+		}
+		for _, f := range child.deferred {
+			parent.Defer(f)
+		}
+		if panicked != nil {
+			panic(panicked)
+		}
+		if defaultStatus != 0 && !w.Written() {
+			w.WriteHeader(defaultStatus)
+		}
+		w.BufferedResponseWriter.Flush()
+	case <-ctx.Done():
+		respondError(parent, http.StatusGatewayTimeout, ctx.Err())
+		go func() {
+			<-done
+			child.finish()
+		}()
+	}
+}
@@ -0,0 +1,75 @@
+package yawf
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRouteTimeoutInTime(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func() (int, string) { return http.StatusCreated, "done" }).Timeout(time.Second)
+
+	rec := serve(s, "GET", "/")
+	if rec.Code != http.StatusCreated || rec.Body.String() != "done" {
+		t.Errorf("got %d %q, want 201 \"done\"", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouteTimeoutExpired(t *testing.T) {
+	s := newTestServer()
+	late := make(chan struct{})
+	s.Get("/", func(res http.ResponseWriter) {
+		defer close(late)
+		time.Sleep(50 * time.Millisecond)
+		res.Header().Set("X-Late", "1")
+		res.Write([]byte("late"))
+	}).Timeout(5 * time.Millisecond)
+
+	rec := serve(s, "GET", "/")
+	<-late
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("got status %d, want 504", rec.Code)
+	}
+	if rec.Header().Get("X-Late") != "" || rec.Body.String() == "late" {
+		t.Errorf("the late response leaked: %v %q", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestRouteTimeoutPanic(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func() { panic("boom") }).Timeout(time.Second)
+
+	if rec := serve(s, "GET", "/"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", rec.Code)
+	}
+}
+
+func TestRouteTimeoutLateHandlerServices(t *testing.T) {
+	for _, pooling := range []bool{false, true} {
+		s := newTestServer()
+		s.SetContextPooling(pooling)
+		late := make(chan struct{})
+		s.Get("/users/:id", func(c Context) {
+			defer close(late)
+			time.Sleep(20 * time.Millisecond)
+			// the request goroutine maps services meanwhile, see After
+			for i := 0; i < 100; i++ {
+				c.Invoke(func(req *http.Request, params PathParams) {
+					if params["id"] != "7" {
+						t.Errorf("got id %q, want 7", params["id"])
+					}
+				})
+			}
+		}).Timeout(5 * time.Millisecond).After(func(c Context) {
+			for i := 0; i < 100; i++ {
+				c.Map(i)
+			}
+		})
+
+		if rec := serve(s, "GET", "/users/7"); rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("got status %d, want 504", rec.Code)
+		}
+		<-late
+	}
+}
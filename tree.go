@@ -0,0 +1,213 @@
+package yawf
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The router indexes the routes with simple patterns, made only of literal segments and
+// whole-segment `:name` parameters, in a tree keyed by path segment. Looking a path up in
+// the tree yields the few routes that may match it in time proportional to the length of
+// the path rather than to the number of routes. The regexp of each candidate then has the
+// final say, so the tree only narrows down the routes to try and never changes which one
+// matches. Routes with any other pattern (regexp groups, `**` and `*name` wildcards,
+// parameters within a segment, trailing slashes...) are candidates for every path. Since
+// the tree only looks up literal segments as they are, the dots of the literal segments of
+// the routes it holds only match dots.
+//
+// The routes of each node, and the other routes, are kept in the order they are tried in,
+// see route.precedes, so that looking up the candidates of a path merely merges a few
+// sorted lists, when there is more than one to merge at all.
+
+// node is a node of the route tree.
+type node struct {
+	static map[string]*node
+	param  *node
+	routes []*route
+}
+
+var staticSegmentReg = regexp.MustCompile(`^[\w\-~%!&',;=@.]+$`)
+var paramSegmentReg = regexp.MustCompile(`^:\w+(?::\w+)?$`)
+
+// treeSegments returns the segments a pattern is indexed under in the route tree, a `:`
+// segment standing for any parameter, or false if the pattern isn't simple enough.
+func treeSegments(pattern string) ([]string, bool) {
+	if pattern == "/" {
+		return []string{""}, true
+	}
+	if !strings.HasPrefix(pattern, "/") || strings.HasSuffix(pattern, "/") {
+		return nil, false
+	}
+	segments := strings.Split(pattern[1:], "/")
+	for i, segment := range segments {
+		switch {
+		case paramSegmentReg.MatchString(segment):
+			segments[i] = ":"
+		case staticSegmentReg.MatchString(segment):
+			segments[i] = escapeNonASCII(segment)
+		default:
+			return nil, false
+		}
+	}
+	return segments, true
+}
+
+func (n *node) insert(segments []string, rt *route) {
+	if len(segments) == 0 {
+		n.routes = insertSorted(n.routes, rt)
+		return
+	}
+
+	var child *node
+	if segments[0] == ":" {
+		if n.param == nil {
+			n.param = &node{}
+		}
+		child = n.param
+	} else {
+		if n.static == nil {
+			n.static = make(map[string]*node)
+		}
+		child = n.static[segments[0]]
+		if child == nil {
+			child = &node{}
+			n.static[segments[0]] = child
+		}
+	}
+	child.insert(segments[1:], rt)
+}
+
+// remove removes the route from the node reached by following the segments, leaving the
+// route slices it filters untouched.
+func (n *node) remove(segments []string, rt *route) {
+	if len(segments) == 0 {
+		routes := make([]*route, 0, len(n.routes))
+		for _, route := range n.routes {
+			if route != rt {
+				routes = append(routes, route)
+			}
+		}
+		n.routes = routes
+		return
+	}
+	child := n.param
+	if segments[0] != ":" {
+		child = n.static[segments[0]]
+	}
+	if child != nil {
+		child.remove(segments[1:], rt)
+	}
+}
+
+// find returns the node reached by following the segments, if any.
+func (n *node) find(segments []string) *node {
+	for _, segment := range segments {
+		child := n.param
+		if segment != ":" {
+			child = n.static[segment]
+		}
+		if child == nil {
+			return nil
+		}
+		n = child
+	}
+	return n
+}
+
+// insertSorted returns a copy of the sorted routes with the route inserted where it is tried.
+// The routes themselves are left untouched, for they may be in use.
+func insertSorted(routes []*route, rt *route) []*route {
+	i := sort.Search(len(routes), func(i int) bool {
+		return rt.precedes(routes[i])
+	})
+	return append(append(routes[:i:i], rt), routes[i:]...)
+}
+
+// sortedRoutes returns a sorted copy of the routes.
+func sortedRoutes(routes []*route) []*route {
+	sorted := append([]*route(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].precedes(sorted[j])
+	})
+	return sorted
+}
+
+// resort puts the route back in order once it may no longer be, see route.precedes. The lock
+// must be held.
+func (r *router) resort(rt *route) {
+	switch {
+	case rt.router != r:
+	case rt.fallback:
+		r.fallbacks = sortedRoutes(r.fallbacks)
+	default:
+		if segments, ok := treeSegments(rt.pattern); ok {
+			if n := r.tree.find(segments); n != nil {
+				n.routes = sortedRoutes(n.routes)
+			}
+			return
+		}
+		r.complex = sortedRoutes(r.complex)
+	}
+}
+
+// collect appends the route lists of the nodes reached by following the path segments.
+func (n *node) collect(segments []string, lists [][]*route) [][]*route {
+	if len(segments) == 0 {
+		if len(n.routes) > 0 {
+			lists = append(lists, n.routes)
+		}
+		return lists
+	}
+	if child := n.static[segments[0]]; child != nil {
+		lists = child.collect(segments[1:], lists)
+	}
+	if n.param != nil && segments[0] != "" {
+		lists = n.param.collect(segments[1:], lists)
+	}
+	return lists
+}
+
+// candidates returns the routes whose pattern may match the escaped path, in the order they
+// are tried in. The slice may belong to the router and must not be changed. The lock must be
+// held.
+func (r *router) candidates(path string) []*route {
+	var lists [][]*route
+	if len(r.complex) > 0 {
+		lists = append(lists, r.complex)
+	}
+	if strings.HasPrefix(path, "/") {
+		lists = r.tree.collect(strings.Split(path[1:], "/"), lists)
+		if len(path) > 1 && strings.HasSuffix(path, "/") {
+			// every pattern matches with an optional trailing slash
+			lists = r.tree.collect(strings.Split(path[1:len(path)-1], "/"), lists)
+		}
+	}
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		return lists[0]
+	}
+	return mergeRoutes(lists)
+}
+
+// mergeRoutes merges sorted lists of routes into one.
+func mergeRoutes(lists [][]*route) []*route {
+	n := 0
+	for _, list := range lists {
+		n += len(list)
+	}
+	routes := make([]*route, 0, n)
+	for len(routes) < n {
+		next := -1
+		for i, list := range lists {
+			if len(list) > 0 && (next < 0 || list[0].precedes(lists[next][0])) {
+				next = i
+			}
+		}
+		routes = append(routes, lists[next][0])
+		lists[next] = lists[next][1:]
+	}
+	return routes
+}
@@ -1,11 +1,69 @@
 package yawf
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"reflect"
+	"strings"
 )
 
+var errHandlerNotFunc = errors.New("yawf handler must be a callable func")
+
 func ValidateHandler(handler Handler) {
-	if reflect.TypeOf(handler).Kind() != reflect.Func {
-		panic("yawf handler must be a callable func")
+	if err := validateHandler(handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+func validateHandler(handler Handler) error {
+	if reflect.TypeOf(handler) == nil || reflect.TypeOf(handler).Kind() != reflect.Func {
+		return errHandlerNotFunc
+	}
+	return nil
+}
+
+// normalizeMethod upper-cases an HTTP method, e.g. "propfind" for WebDAV's PROPFIND, after
+// checking that it is a valid token. "*" stands for any method.
+func normalizeMethod(method string) (string, error) {
+	if method == "*" {
+		return method, nil
+	}
+	if method == "" {
+		return "", errors.New("missing method")
+	}
+	for i := 0; i < len(method); i++ {
+		if !isTokenChar(method[i]) {
+			return "", fmt.Errorf("invalid method %q", method)
+		}
+	}
+	return strings.ToUpper(method), nil
+}
+
+// isTokenChar returns whether the character may appear in a token as defined by RFC 9110.
+func isTokenChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// parseQuery adds the parameters of the query string to values, as url.ParseQuery does
+// except that it fills an existing map. Malformed parameters are skipped.
+func parseQuery(values url.Values, query string) {
+	for query != "" {
+		var key string
+		key, query, _ = strings.Cut(query, "&")
+		if key == "" || strings.Contains(key, ";") {
+			continue
+		}
+		key, value, _ := strings.Cut(key, "=")
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			continue
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		values[key] = append(values[key], value)
 	}
 }
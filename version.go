@@ -0,0 +1,89 @@
+package yawf
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apiVersion is a version of the routes under the prefix of a group, added with Version.
+type apiVersion struct {
+	name string
+	// parent is the prefix of the group the version was added to, prefix its own.
+	parent string
+	prefix string
+}
+
+// acceptVersionReg extracts the version of a vendor media type like "application/vnd.myapp.v2+json".
+var acceptVersionReg = regexp.MustCompile(`vnd\.[^\s;,+]+?\.(v\d+(?:\.\d+)*)(?:[+;,\s]|$)`)
+
+func (r *router) Version(name string, fn func(Router), h ...Handler) {
+	parent := r.currentGroup().pattern
+	r.Group("/"+name, func(sub Router) {
+		r.mu.Lock()
+		r.versions = append(r.versions, apiVersion{name, parent, r.current.pattern})
+		r.mu.Unlock()
+		fn(sub)
+	}, h...)
+}
+
+// versionPaths returns the escaped paths to match in turn: the path of the request itself,
+// preceded by its path within the version named in the Accept header, or followed by its path
+// within the latest version when the header names none. The lock must be held.
+func (r *router) versionPaths(accept string, path string) []string {
+	var wanted string
+	if m := acceptVersionReg.FindStringSubmatch(accept); m != nil {
+		wanted = m[1]
+	}
+
+	var latest, named *apiVersion
+	for i, v := range r.versions {
+		if !hasPathPrefix(path, v.parent) && v.parent != "" {
+			continue
+		}
+		if hasPathPrefix(path, v.prefix) {
+			// the path already names a version
+			return []string{path}
+		}
+		if v.name == wanted && (named == nil || len(v.parent) > len(named.parent)) {
+			named = &r.versions[i]
+		}
+		// the versions of the innermost group win
+		if latest == nil || len(v.parent) > len(latest.parent) ||
+			len(v.parent) == len(latest.parent) && versionLess(latest.name, v.name) {
+			latest = &r.versions[i]
+		}
+	}
+	if named != nil {
+		return []string{versionPath(*named, path), path}
+	}
+	if latest == nil {
+		return []string{path}
+	}
+	return []string{path, versionPath(*latest, path)}
+}
+
+// versionPath returns the path within the version of a path under the parent of the version.
+func versionPath(v apiVersion, path string) string {
+	return strings.TrimRight(v.prefix, "/") + strings.TrimPrefix(path, strings.TrimRight(v.parent, "/"))
+}
+
+// versionLess compares versions like "v2" and "v10" or "v1.2" by their numbers.
+func versionLess(a string, b string) bool {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr != nil || berr != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+			continue
+		}
+		if an != bn {
+			return an < bn
+		}
+	}
+	return len(as) < len(bs)
+}
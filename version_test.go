@@ -0,0 +1,122 @@
+package yawf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newVersionServer returns a server with the v1, v2 and v10 versions of /users/:id, /legacy
+// in v1 only, and /health outside of any version.
+func newVersionServer() YawfServer {
+	s := newTestServer()
+	for _, name := range []string{"v1", "v10", "v2"} {
+		name := name
+		s.Version(name, func(r Router) {
+			r.Get("/users/:id", func(p PathParams) string { return name + " " + p["id"] })
+		})
+	}
+	s.Version("v1", func(r Router) {
+		r.Get("/legacy", func() string { return "v1 legacy" })
+	})
+	s.Get("/health", func() string { return "ok" })
+	return s
+}
+
+func TestVersion(t *testing.T) {
+	s := newVersionServer()
+	tests := []struct {
+		path   string
+		accept string
+		body   string
+	}{
+		// the prefix wins over the header
+		{"/v1/users/7", "", "v1 7"},
+		{"/v2/users/7", "application/vnd.myapp.v10+json", "v2 7"},
+		// the version named in the Accept header
+		{"/users/7", "application/vnd.myapp.v2+json", "v2 7"},
+		{"/users/7", "application/vnd.myapp.v1", "v1 7"},
+		{"/users/7", "text/html, application/vnd.myapp.v2+json;q=0.9", "v2 7"},
+		// "v10" is later than "v2"
+		{"/users/7", "", "v10 7"},
+		{"/users/7", "application/json", "v10 7"},
+		// a version without the route falls back to the route without a version
+		{"/health", "application/vnd.myapp.v2+json", "ok"},
+		// only the latest version is tried without a version in the header
+		{"/legacy", "", ""},
+		{"/legacy", "application/vnd.myapp.v1+json", "v1 legacy"},
+		{"/users/7", "application/vnd.myapp.v3+json", "v10 7"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rec := s.ServeTest(req)
+		if test.body == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s with %q: got %d %q, want 404", test.path, test.accept, rec.Code, rec.Body.String())
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != test.body {
+			t.Errorf("%s with %q: got %d %q, want %q", test.path, test.accept, rec.Code, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestVersionWithinGroup(t *testing.T) {
+	s := newTestServer()
+	s.Version("v1", func(r Router) {
+		r.Get("/status", func() string { return "root v1" })
+	})
+	s.Group("/api", func(r Router) {
+		r.Version("v1", func(r Router) {
+			r.Get("/status", func() string { return "api v1" })
+		})
+		r.Version("v2", func(r Router) {
+			r.Get("/status", func() string { return "api v2" })
+		})
+	})
+
+	tests := []struct {
+		path   string
+		accept string
+		body   string
+	}{
+		{"/api/status", "", "api v2"},
+		{"/api/status", "application/vnd.myapp.v1+json", "api v1"},
+		{"/api/v1/status", "application/vnd.myapp.v2+json", "api v1"},
+		{"/status", "", "root v1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept", test.accept)
+		if body := s.ServeTest(req).Body.String(); body != test.body {
+			t.Errorf("%s with %q: got %q, want %q", test.path, test.accept, body, test.body)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"v1", "v2", true},
+		{"v2", "v10", true},
+		{"v10", "v2", false},
+		{"v2", "v2", false},
+		{"v1.2", "v1.10", true},
+		{"v1", "v1.1", true},
+		{"v1.1", "v1", false},
+		{"v2.0", "v10", true},
+		{"vbeta", "valpha", false},
+		{"v1", "vbeta", true},
+	}
+	for _, test := range tests {
+		if less := versionLess(test.a, test.b); less != test.less {
+			t.Errorf("versionLess(%q, %q) = %t, want %t", test.a, test.b, less, test.less)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package yawf
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// WebSocket message types, as passed to WriteMessage and returned by ReadMessage.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// The status codes of the close frames sent when the peer breaks the protocol.
+const (
+	closeProtocolError  = 1002
+	closeInvalidPayload = 1007
+	closeMessageTooBig  = 1009
+)
+
+// MaxWebSocketMessage is the size above which the messages read from a WebSocketConn are
+// rejected, closing the connection.
+var MaxWebSocketMessage = 32 << 20
+
+// ErrWebSocketClosed is returned by ReadMessage once the peer closed the connection.
+var ErrWebSocketClosed = errors.New("websocket: connection closed")
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketConn is a server side WebSocket connection, as injected into the handlers of a
+// route added with WebSocket. Pings are answered as messages are read. It may be read by
+// one goroutine while others write to it.
+type WebSocketConn struct {
+	conn      net.Conn
+	buf       *bufio.ReadWriter
+	wmu       sync.Mutex
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// WebSocket adds a GET route that upgrades the requests to the WebSocket protocol before
+// calling the handlers, which get the *WebSocketConn injected. Their return values are
+// ignored and the connection is closed once they are done. Requests that aren't valid
+// upgrades are answered with a 400 through the ErrorHandler, or a 426 for an unsupported
+// version of the protocol.
+func (r *router) WebSocket(pattern string, h ...Handler) Route {
+	rt := r.addRoute("GET", pattern, append([]Handler{upgradeWebSocket}, h...))
+	return rt.SetReturnHandler(func(Context, []reflect.Value) {})
+}
+
+// upgradeWebSocket is the handler performing the upgrade for a WebSocket route.
+func upgradeWebSocket(c Context, res http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(req.Header, "Connection", "upgrade") || !headerHasToken(req.Header, "Upgrade", "websocket") || key == "" {
+		respondError(c, http.StatusBadRequest, errors.New("websocket: not a websocket upgrade request"))
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		res.Header().Set("Sec-WebSocket-Version", "13")
+		respondError(c, http.StatusUpgradeRequired, errors.New("websocket: unsupported version"))
+		return
+	}
+	hijacker, ok := res.(http.Hijacker)
+	if !ok {
+		respondError(c, http.StatusInternalServerError, errors.New("websocket: the ResponseWriter can't be hijacked"))
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := buf.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &WebSocketConn{conn: conn, buf: buf}
+	defer ws.Close()
+	c.Map(ws)
+	c.Next()
+}
+
+// headerHasToken returns whether the comma separated values of the header contain the token.
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage reads the next message, returning its type, TextMessage or BinaryMessage, and
+// its payload. It returns ErrWebSocketClosed once the peer has closed the connection. Frames
+// breaking the protocol, e.g. with reserved bits or opcodes, and text messages that aren't
+// valid UTF-8 close the connection with the matching status code and return an error.
+func (ws *WebSocketConn) ReadMessage() (int, []byte, error) {
+	var messageType int
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			if len(payload) == 1 {
+				return 0, nil, ws.fail(closeProtocolError, "invalid close frame")
+			}
+			if len(payload) > 2 && !utf8.Valid(payload[2:]) {
+				return 0, nil, ws.fail(closeInvalidPayload, "invalid UTF-8 close reason")
+			}
+			ws.closeWith(payload)
+			return 0, nil, ErrWebSocketClosed
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, ws.fail(closeProtocolError, "unexpected continuation frame")
+			}
+		default:
+			if messageType != 0 {
+				return 0, nil, ws.fail(closeProtocolError, "unfinished fragmented message")
+			}
+			messageType = opcode
+		}
+		if len(message)+len(payload) > MaxWebSocketMessage {
+			return 0, nil, ws.fail(closeMessageTooBig, "message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, ws.fail(closeInvalidPayload, "invalid UTF-8 text message")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+// readFrame reads a frame, unmasking its payload.
+func (ws *WebSocketConn) readFrame() (bool, int, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.buf, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := int(head[0] & 0x0f)
+	switch {
+	case head[0]&0x70 != 0:
+		// no extension is negotiated, so the reserved bits must be clear
+		return false, 0, nil, ws.fail(closeProtocolError, "reserved bits set")
+	case opcode > BinaryMessage && opcode < opClose || opcode > opPong:
+		return false, 0, nil, ws.fail(closeProtocolError, fmt.Sprintf("reserved opcode %d", opcode))
+	case head[1]&0x80 == 0:
+		return false, 0, nil, ws.fail(closeProtocolError, "unmasked client frame")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, ws.fail(closeProtocolError, "fragmented or oversized control frame")
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.buf, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.buf, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(MaxWebSocketMessage) {
+		return false, 0, nil, ws.fail(closeMessageTooBig, "message too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.buf, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.buf, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage writes a message of the given type, TextMessage or BinaryMessage.
+func (ws *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return ws.writeFrame(messageType, data)
+}
+
+// writeFrame writes an unfragmented, unmasked frame.
+func (ws *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	header := []byte{0x80 | byte(opcode)}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if _, err := ws.buf.Write(header); err != nil {
+		return err
+	}
+	if _, err := ws.buf.Write(payload); err != nil {
+		return err
+	}
+	return ws.buf.Flush()
+}
+
+// Close closes the underlying connection, sending a close frame beforehand. Later calls have
+// no effect.
+func (ws *WebSocketConn) Close() error {
+	return ws.closeWith(nil)
+}
+
+// closeWith closes the connection like Close, with the payload in the close frame.
+func (ws *WebSocketConn) closeWith(payload []byte) error {
+	ws.closeOnce.Do(func() {
+		ws.writeFrame(opClose, payload)
+		ws.closeErr = ws.conn.Close()
+	})
+	return ws.closeErr
+}
+
+// fail closes the connection with the status code when the peer breaks the protocol, and
+// returns the error describing how.
+func (ws *WebSocketConn) fail(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	ws.closeWith(append(payload, reason...))
+	return errors.New("websocket: " + reason)
+}
+
+// RemoteAddr returns the address of the peer.
+func (ws *WebSocketConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
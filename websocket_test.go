@@ -0,0 +1,233 @@
+package yawf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebSocketServer starts a server echoing the messages of /ws, sending the error ending
+// each connection on the channel.
+func newWebSocketServer(t *testing.T) (*httptest.Server, chan error) {
+	errs := make(chan error, 1)
+	s := newTestServer()
+	s.WebSocket("/ws", func(ws *WebSocketConn) {
+		for {
+			messageType, message, err := ws.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := ws.WriteMessage(messageType, message); err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+	ts := httptest.NewServer(s.(http.Handler))
+	t.Cleanup(ts.Close)
+	return ts, errs
+}
+
+// dialWebSocket performs the opening handshake of /ws with the key, returning the connection
+// and the response.
+func dialWebSocket(t *testing.T, ts *httptest.Server, key string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, res
+}
+
+// writeClientFrame writes a masked frame, as clients do, with the first byte given as is.
+func writeClientFrame(conn net.Conn, first byte, payload []byte) {
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readServerFrame reads an unmasked frame, returning its first byte and its payload.
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("the server masked a frame")
+	}
+	length := int(head[1])
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0], payload
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	ts, _ := newWebSocketServer(t)
+	// the sample handshake of RFC 6455
+	_, _, res := dialWebSocket(t, ts, "dGhlIHNhbXBsZSBub25jZQ==")
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", res.StatusCode)
+	}
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", accept)
+	}
+	if !headerHasToken(res.Header, "Upgrade", "websocket") || !headerHasToken(res.Header, "Connection", "upgrade") {
+		t.Errorf("got headers %v", res.Header)
+	}
+}
+
+func TestWebSocketNotUpgraded(t *testing.T) {
+	s := newTestServer()
+	s.WebSocket("/ws", func(ws *WebSocketConn) {
+		t.Error("the handler ran without an upgrade")
+	})
+
+	if rec := serve(s, "GET", "/ws"); rec.Code != http.StatusBadRequest {
+		t.Errorf("plain GET: got status %d, want 400", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	rec := s.ServeTest(req)
+	if rec.Code != http.StatusUpgradeRequired || rec.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("old version: got status %d and version %q, want 426 and 13", rec.Code, rec.Header().Get("Sec-WebSocket-Version"))
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	ts, _ := newWebSocketServer(t)
+	conn, r, _ := dialWebSocket(t, ts, "dGhlIHNhbXBsZSBub25jZQ==")
+
+	// masked text message
+	writeClientFrame(conn, 0x81, []byte("hello"))
+	if first, payload := readServerFrame(t, r); first != 0x81 || string(payload) != "hello" {
+		t.Errorf("text: got %#x %q", first, payload)
+	}
+
+	// binary message of a 16 bit length
+	long := []byte(strings.Repeat("x", 300))
+	writeClientFrame(conn, 0x82, long)
+	if first, payload := readServerFrame(t, r); first != 0x82 || string(payload) != string(long) {
+		t.Errorf("binary: got %#x and %d bytes", first, len(payload))
+	}
+
+	// fragmented message with a ping in between, answered first
+	writeClientFrame(conn, 0x01, []byte("hel"))
+	writeClientFrame(conn, 0x89, []byte("are you there"))
+	writeClientFrame(conn, 0x80, []byte("lo"))
+	if first, payload := readServerFrame(t, r); first != 0x8a || string(payload) != "are you there" {
+		t.Errorf("ping: got %#x %q, want a pong", first, payload)
+	}
+	if first, payload := readServerFrame(t, r); first != 0x81 || string(payload) != "hello" {
+		t.Errorf("fragmented: got %#x %q", first, payload)
+	}
+
+	// unsolicited pongs are ignored
+	writeClientFrame(conn, 0x8a, nil)
+	writeClientFrame(conn, 0x81, []byte("still here"))
+	if _, payload := readServerFrame(t, r); string(payload) != "still here" {
+		t.Errorf("after pong: got %q", payload)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	ts, errs := newWebSocketServer(t)
+	conn, r, _ := dialWebSocket(t, ts, "dGhlIHNhbXBsZSBub25jZQ==")
+
+	writeClientFrame(conn, 0x88, append(binary.BigEndian.AppendUint16(nil, 1000), "bye"...))
+	first, payload := readServerFrame(t, r)
+	if first != 0x88 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != 1000 || string(payload[2:]) != "bye" {
+		t.Errorf("got %#x %q, want the close frame echoed", first, payload)
+	}
+	if err := <-errs; err != ErrWebSocketClosed {
+		t.Errorf("got error %v, want ErrWebSocketClosed", err)
+	}
+	// the close frame is only sent once before the connection is closed
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v after the close frame, want EOF", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames func(conn net.Conn)
+		code   uint16
+	}{
+		{"reserved bit", func(conn net.Conn) { writeClientFrame(conn, 0xc1, []byte("x")) }, closeProtocolError},
+		{"reserved bit 3", func(conn net.Conn) { writeClientFrame(conn, 0x91, []byte("x")) }, closeProtocolError},
+		{"reserved data opcode", func(conn net.Conn) { writeClientFrame(conn, 0x83, []byte("x")) }, closeProtocolError},
+		{"reserved control opcode", func(conn net.Conn) { writeClientFrame(conn, 0x8b, nil) }, closeProtocolError},
+		{"oversized ping", func(conn net.Conn) { writeClientFrame(conn, 0x89, make([]byte, 126)) }, closeProtocolError},
+		{"fragmented ping", func(conn net.Conn) { writeClientFrame(conn, 0x09, []byte("x")) }, closeProtocolError},
+		{"lone continuation", func(conn net.Conn) { writeClientFrame(conn, 0x80, []byte("x")) }, closeProtocolError},
+		{"interleaved message", func(conn net.Conn) {
+			writeClientFrame(conn, 0x01, []byte("x"))
+			writeClientFrame(conn, 0x81, []byte("y"))
+		}, closeProtocolError},
+		{"unmasked", func(conn net.Conn) { conn.Write([]byte{0x81, 0x01, 'x'}) }, closeProtocolError},
+		{"invalid UTF-8", func(conn net.Conn) { writeClientFrame(conn, 0x81, []byte{0xff, 0xfe}) }, closeInvalidPayload},
+		{"invalid UTF-8 across fragments", func(conn net.Conn) {
+			writeClientFrame(conn, 0x01, []byte{0xe2, 0x82})
+			writeClientFrame(conn, 0x80, []byte{0x41})
+		}, closeInvalidPayload},
+	}
+	for _, test := range tests {
+		ts, errs := newWebSocketServer(t)
+		conn, r, _ := dialWebSocket(t, ts, "dGhlIHNhbXBsZSBub25jZQ==")
+		test.frames(conn)
+
+		first, payload := readServerFrame(t, r)
+		if first != 0x88 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != test.code {
+			t.Errorf("%s: got %#x %q, want a close frame with %d", test.name, first, payload, test.code)
+		}
+		if err := <-errs; err == nil || errors.Is(err, ErrWebSocketClosed) {
+			t.Errorf("%s: got error %v, want a protocol error", test.name, err)
+		}
+	}
+
+	// a well-formed text message split in the middle of a character is accepted
+	ts, _ := newWebSocketServer(t)
+	conn, r, _ := dialWebSocket(t, ts, "dGhlIHNhbXBsZSBub25jZQ==")
+	writeClientFrame(conn, 0x01, []byte{0xe2, 0x82})
+	writeClientFrame(conn, 0x80, []byte{0xac})
+	if _, payload := readServerFrame(t, r); string(payload) != "€" {
+		t.Errorf("split character: got %q", payload)
+	}
+}
@@ -0,0 +1,179 @@
+package yawf
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// YAML is supported without any dependency by going through JSON, of which YAML is a
+// superset: values are marshaled to JSON, so that their fields are named and omitted as
+// with encoding/json, then the JSON document is written back in the block style of YAML.
+
+func init() {
+	encoders["application/yaml"] = encodeYAML
+}
+
+// YAML wraps a value returned by a handler to have it encoded as YAML.
+func YAML(v interface{}) Encoded {
+	return Encoded{"application/yaml", v}
+}
+
+func (c *context) YAML(status int, v interface{}) {
+	c.render(status, YAML(v))
+}
+
+// jsonField is a member of a JSON object, as decoded by decodeOrdered.
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+// encodeYAML is the Encoder of application/yaml.
+func encodeYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	doc, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, doc, 0, false)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// decodeOrdered decodes the next JSON value, objects as []jsonField to keep their order.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		fields := []jsonField{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, jsonField{key.(string), value})
+		}
+		_, err = dec.Token()
+		return fields, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for dec.More() {
+			item, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token()
+		return items, err
+	}
+	return tok, nil
+}
+
+// writeYAML writes a node at the indentation. Inline is set when the node follows the "- " of
+// a sequence item, on the same line.
+func writeYAML(buf *bytes.Buffer, node interface{}, indent int, inline bool) {
+	pad := strings.Repeat(" ", indent)
+	switch v := node.(type) {
+	case []jsonField:
+		if len(v) == 0 {
+			buf.WriteString("{}\n")
+			return
+		}
+		for i, field := range v {
+			if i > 0 || !inline {
+				buf.WriteString(pad)
+			}
+			buf.WriteString(yamlScalar(field.key) + ":")
+			writeYAMLValue(buf, field.value, indent)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]\n")
+			return
+		}
+		for i, item := range v {
+			if i > 0 || !inline {
+				buf.WriteString(pad)
+			}
+			buf.WriteString("- ")
+			writeYAML(buf, item, indent+2, true)
+		}
+	default:
+		buf.WriteString(yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLValue writes the value of a mapping, after its key.
+func writeYAMLValue(buf *bytes.Buffer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case []jsonField:
+		if len(v) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, v, indent+2, false)
+			return
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, v, indent+2, false)
+			return
+		}
+	}
+	buf.WriteString(" ")
+	writeYAML(buf, value, indent+2, true)
+}
+
+// yamlScalar renders a JSON scalar, quoting the strings that would otherwise be read as
+// something else.
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlNeedsQuotes(v) {
+			return strconv.Quote(v)
+		}
+		return v
+	}
+	return ""
+}
+
+// yamlPlainReg matches the strings written as plain scalars, identifier-like ones that YAML
+// parsers read as strings whatever the version of YAML they implement, but for a few words.
+// Anything else, e.g. 0x1F, 0o17, 2024-01-02 or <<, is quoted.
+var yamlPlainReg = regexp.MustCompile(`^[A-Za-z_](?:[\w.\-/ ]*[\w.\-/])?$`)
+
+// yamlNeedsQuotes returns whether a string can't be written as a plain scalar.
+func yamlNeedsQuotes(s string) bool {
+	if !yamlPlainReg.MatchString(s) {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+	return false
+}
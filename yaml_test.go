@@ -0,0 +1,75 @@
+package yawf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// yamlNonStringReg matches the plain scalars that YAML 1.1 or 1.2 parsers resolve to
+// something other than a string: nulls, booleans, numbers in any base, sexagesimals,
+// infinities, NaN, timestamps and the merge key.
+var yamlNonStringReg = regexp.MustCompile(`(?i)^(?:~|null|true|false|yes|no|on|off|y|n|` +
+	`[-+]?(?:0b[01_]+|0o?[0-7_]+|0x[0-9a-f_]+|[0-9][0-9_]*(?::[0-5]?[0-9])*|` +
+	`(?:[0-9][0-9_]*)?\.?[0-9_]*(?:e[-+]?[0-9]+)?|\.inf)|\.nan|` +
+	`[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}(?:[Tt ].*)?|<<)$`)
+
+// readYAMLScalar reads back a scalar written by yamlScalar, failing if a YAML parser would
+// read a plain one as anything but the string.
+func readYAMLScalar(t *testing.T, s string) string {
+	if strings.HasPrefix(s, `"`) {
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			t.Fatalf("unreadable quoted scalar %s: %v", s, err)
+		}
+		return v
+	}
+	if yamlNonStringReg.MatchString(s) || strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`") {
+		t.Errorf("plain scalar %q isn't read as a string", s)
+	}
+	return s
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	values := []string{
+		"", " ", "name", "snake_case", "kebab-case", "a.b/c", "two words", "trailing ",
+		"0x1F", "0o17", "017", "0b101", "1_000", "12", "-3", "+4", "1.5", "1e3", ".5",
+		"1:20", ".inf", "-.Inf", ".NaN", "2024-01-02", "2024-01-02T10:00:00Z", "<<",
+		"~", "null", "Null", "true", "False", "yes", "No", "on", "OFF", "y", "N",
+		"- item", "? key", "key: value", "a #comment", "ends:", "[1]", "{a}", "*ref",
+		"&anchor", "!tag", "|", ">", "'single'", `"double"`, "%dir", "@at", "`tick`",
+		"line\nbreak", "tab\there", "\x7f", "café",
+	}
+
+	var buf bytes.Buffer
+	if err := encodeYAML(&buf, values); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(values) {
+		t.Fatalf("expected %d items, got:\n%s", len(values), buf.String())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "- ") {
+			t.Fatalf("expected a sequence item, got %q", line)
+		}
+		if v := readYAMLScalar(t, line[2:]); v != values[i] {
+			t.Errorf("expected %q to be read back, got %q", values[i], v)
+		}
+	}
+}
+
+func TestYAMLQuotes(t *testing.T) {
+	for _, s := range []string{"0x1F", "0o17", "2024-01-02", "<<", "yes", "1.5", "a: b", ""} {
+		if !yamlNeedsQuotes(s) {
+			t.Errorf("expected %q to be quoted", s)
+		}
+	}
+	for _, s := range []string{"name", "snake_case", "kebab-case", "v1.2", "a/b", "two words"} {
+		if yamlNeedsQuotes(s) {
+			t.Errorf("expected %q to be plain", s)
+		}
+	}
+}
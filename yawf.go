@@ -1,14 +1,18 @@
 package yawf
 
 import (
+	gocontext "context"
 	"errors"
+	"fmt"
 	"github.com/codegangsta/inject"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -27,30 +31,101 @@ type YawfServer interface {
 	SetListener(net.Listener)
 	Listener() net.Listener
 	Listen() error
+	// Run serves requests on the listeners until the server is stopped, listening on the
+	// configured Address first if no listener has been set.
 	Run() error
 	RunOnAddress(string) error
+	// RunOnAddresses listens on every address and serves the same routes on all of them until
+	// Stop is called. It returns the errors of all the listeners joined together.
+	RunOnAddresses(...string) error
 
+	// SetLogger sets the logger of the server, which the handlers get when they inject a
+	// *log.Logger. Its entries don't tell the requests apart: for entries prefixed with the
+	// method, path and RequestID of their request, Use RequestLogger before the handlers.
 	SetLogger(*log.Logger)
 	Logger() *log.Logger
 
+	// MapGlobal maps a service on the server so that it can be injected into the handlers of
+	// every request. Services mapped on a request context take precedence over global ones.
+	MapGlobal(interface{})
+	// MapGlobalTo maps a service on the server as the interface pointed to by the second argument.
+	MapGlobalTo(interface{}, interface{})
+	// SetParentInjector sets an injector in which the services that are neither mapped on the
+	// request nor on the server are looked up, so that the singletons of an application, e.g.
+	// its database pool or configuration, can be shared by several servers or set up by the
+	// application's own bootstrapping without mapping each of them on the server.
+	SetParentInjector(inject.Injector)
+
+	// SetErrorHandler sets the handler writing the responses of the errors raised by the framework.
+	SetErrorHandler(ErrorHandler)
+
+	// SetCookieKeys sets the keys of the signed and encrypted cookies, the first one being used
+	// for the cookies being set. See CookieKeys.
+	SetCookieKeys(...[]byte)
+
+	// SetRenderOptions sets how the values returned by handlers are encoded.
+	SetRenderOptions(RenderOptions)
+	// SetFormOptions sets how the request bodies are parsed into FormParams.
+	SetFormOptions(FormOptions)
+
+	// SetTrustedProxies sets the CIDRs or addresses of the proxies whose forwarding headers are trusted.
+	SetTrustedProxies(...string) error
+
+	// ConfigureServer registers a function called with the http.Server just before Run starts
+	// serving, to set fields such as ConnState, BaseContext, TLSNextProto or ErrorLog.
+	ConfigureServer(func(*http.Server))
+	// Shutdown stops accepting connections and waits for the in-flight requests to complete
+	// or for the context to be done, whichever comes first, after which Run returns. It only
+	// runs once: the calls made meanwhile, e.g. by a failing listener, wait for it to be done
+	// and return nil, as do the later ones.
+	Shutdown(gocontext.Context) error
+	// OnShutdown registers a function called once Shutdown or Stop has waited for the in-flight
+	// requests, before Run returns. Functions are called in registration order and a panic in
+	// one of them is logged without preventing the others from running.
+	OnShutdown(func())
+	// Stop shuts the server down, waiting at most the graceful delay for in-flight requests.
 	Stop()
+	// SetGracefulDelay sets how long Stop waits for in-flight requests, 3 seconds by default.
 	SetGracefulDelay(time.Duration)
+	// SetRequestTimeout sets a deadline on the context.Context of every request, which handlers
+	// can inject to have their outbound work canceled once it is exceeded. Unlike a timeout
+	// middleware it doesn't write any response by itself. Zero, the default, disables it.
+	SetRequestTimeout(time.Duration)
+	// SetContextPooling makes the server reuse the contexts of the requests it has handled,
+	// along with their maps of services, Headers and QueryParams, instead of allocating new
+	// ones for every request. Handlers must then not keep the Context or the services mapped
+	// on it once they have returned, e.g. to use them from a goroutine, which should be given
+	// a Copy of the Context instead. It is off by default.
+	SetContextPooling(bool)
+
+	// ServeTest runs the request through the server without a listener and returns the recorded response.
+	ServeTest(*http.Request) *httptest.ResponseRecorder
 }
 
 type yawf struct {
 	inject.Injector
 	handlers []Handler
 	action   Handler
-	listener *net.Listener
-	logger   *log.Logger
-	address  string
+	// listeners are all served by Run, the first one being the main listener.
+	listeners []net.Listener
+	logger    *log.Logger
+	address   string
 
-	// keep trace on the number of current active request
-	activeCount int32
-	cClose      chan bool
+	// server is set by Run and read by Shutdown, which may be called from another goroutine.
+	server          atomic.Pointer[http.Server]
+	configureServer func(*http.Server)
+	onShutdown      []func()
+	cClose          chan bool
 
-	isStopping    bool
+	// stopping is set once Shutdown starts, which only happens once.
+	stopping      atomic.Bool
+	shutdownOnce  sync.Once
 	gracefulDelay time.Duration
+
+	requestTimeout time.Duration
+
+	poolContexts bool
+	contexts     sync.Pool
 }
 
 type classicYawf struct {
@@ -71,23 +146,52 @@ func New() YawfServer {
 	y.SetLogger(y.logger)
 	y.Map(defaultRouterReturnHandler())
 	y.Map(defaultMiddlewareReturnHandler())
+	y.Map(defaultErrorHandler())
 	y.SetAction(r.Handle)
 	return &classicYawf{y, r}
 }
 
 func (s *yawf) Listen() error {
 	listener, err := net.Listen("tcp", s.Address())
+	if err != nil {
+		return err
+	}
 	s.SetListener(listener)
-	return err
+	return nil
 }
 
 func (s *yawf) Run() error {
-	if s.listener == nil {
-		s.Logger().Fatalln("failed to run server before listening")
-		return errors.New("failed to run server before listening")
+	if len(s.listeners) == 0 {
+		// nobody called Listen, do it on the configured address
+		if err := s.Listen(); err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", s.Address(), err)
+		}
 	}
+
 	server := &http.Server{Addr: s.Address(), Handler: s}
-	err := server.Serve(s.Listener())
+	if s.configureServer != nil {
+		s.configureServer(server)
+	}
+	s.server.Store(server)
+
+	errs := make(chan error, len(s.listeners))
+	for _, listener := range s.listeners {
+		go func(listener net.Listener) {
+			err := server.Serve(listener)
+			if err == http.ErrServerClosed {
+				err = nil
+			} else if !s.stopping.Load() {
+				// one listener failing brings the others down with it
+				go s.Stop()
+			}
+			errs <- err
+		}(listener)
+	}
+
+	var err error
+	for range s.listeners {
+		err = errors.Join(err, <-errs)
+	}
 	<-s.cClose
 	return err
 }
@@ -101,16 +205,80 @@ func (s *yawf) RunOnAddress(address string) error {
 	return s.Run()
 }
 
+func (s *yawf) RunOnAddresses(addresses ...string) error {
+	if len(addresses) == 0 {
+		return errors.New("no address to run on")
+	}
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	s.SetAddress(addresses[0])
+	s.listeners = listeners
+	return s.Run()
+}
+
 func (s *yawf) SetGracefulDelay(delay time.Duration) {
 	s.gracefulDelay = delay
 }
 
-func (s *yawf) Stop() {
-	s.isStopping = true
-	s.Listener().Close()
-	if s.activeCount == 0 {
+func (s *yawf) SetRequestTimeout(timeout time.Duration) {
+	s.requestTimeout = timeout
+}
+
+func (s *yawf) SetContextPooling(pool bool) {
+	s.poolContexts = pool
+}
+
+func (s *yawf) ConfigureServer(fn func(*http.Server)) {
+	s.configureServer = fn
+}
+
+func (s *yawf) Shutdown(ctx gocontext.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		s.stopping.Store(true)
+		if server := s.server.Load(); server != nil {
+			if err = server.Shutdown(ctx); err != nil {
+				server.Close()
+			}
+		} else {
+			for _, listener := range s.listeners {
+				listener.Close()
+			}
+		}
+		for _, fn := range s.onShutdown {
+			s.runShutdownFunc(fn)
+		}
 		s.cClose <- true
-	}
+	})
+	return err
+}
+
+func (s *yawf) OnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+func (s *yawf) runShutdownFunc(fn func()) {
+	defer func() {
+		if e := recover(); e != nil {
+			s.Logger().Printf("PANIC in shutdown function: %v", e)
+		}
+	}()
+	fn()
+}
+
+func (s *yawf) Stop() {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), s.gracefulDelay)
+	defer cancel()
+	s.Shutdown(ctx)
 }
 
 func (s *yawf) Use(handler Handler) {
@@ -140,11 +308,14 @@ func (s *yawf) Address() string {
 }
 
 func (s *yawf) SetListener(listener net.Listener) {
-	s.listener = &listener
+	s.listeners = []net.Listener{listener}
 }
 
 func (s *yawf) Listener() net.Listener {
-	return *s.listener
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0]
 }
 
 func (s *yawf) SetLogger(logger *log.Logger) {
@@ -156,34 +327,93 @@ func (s *yawf) Logger() *log.Logger {
 	return s.logger
 }
 
+// MapGlobal maps on the server injector, which is the parent of every request context.
+func (s *yawf) MapGlobal(val interface{}) {
+	s.Map(val)
+}
+
+func (s *yawf) MapGlobalTo(val interface{}, ifacePtr interface{}) {
+	s.MapTo(val, ifacePtr)
+}
+
+func (s *yawf) SetParentInjector(parent inject.Injector) {
+	s.Injector.SetParent(parent)
+}
+
+func (s *yawf) SetErrorHandler(handler ErrorHandler) {
+	s.Map(handler)
+}
+
+func (s *yawf) SetTrustedProxies(cidrs ...string) error {
+	proxies, err := ParseTrustedProxies(cidrs...)
+	if err != nil {
+		return err
+	}
+	s.Map(proxies)
+	return nil
+}
+
+func (s *yawf) SetCookieKeys(keys ...[]byte) {
+	s.Map(CookieKeys(keys))
+}
+
+func (s *yawf) SetRenderOptions(options RenderOptions) {
+	s.Map(options)
+}
+
+func (s *yawf) SetFormOptions(options FormOptions) {
+	s.Map(options)
+}
+
 // ServeHTTP is the HTTP Entry point for a yawf instance. Useful if you want to control your own HTTP server.
 func (s *yawf) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	s.CreateContext(res, req).Next()
-	activeCount := atomic.AddInt32(&s.activeCount, -1)
-	if s.isStopping && activeCount == 0 {
-		time.Sleep(s.gracefulDelay)
-		s.cClose <- true
+	cancel := gocontext.CancelFunc(func() {})
+	if s.requestTimeout > 0 {
+		var ctx gocontext.Context
+		ctx, cancel = gocontext.WithTimeout(req.Context(), s.requestTimeout)
+		req = req.WithContext(ctx)
+	}
+	if s.poolContexts {
+		c := s.acquireContext(res, req)
+		s.handle(c.context)
+		s.releaseContext(c)
+	} else {
+		s.handle(s.CreateContext(res, req).(*context))
 	}
+	cancel()
+}
+
+// handle runs the handlers of the request, then the functions registered with Defer and After.
+func (s *yawf) handle(c *context) {
+	defer c.finish()
+	c.Next()
 }
 
 func (s *yawf) CreateContext(res http.ResponseWriter, req *http.Request) Context {
 	c := NewContext(s.handlers, s.action, res)
+	s.mapRequest(c, req, make(Headers), make(QueryParams))
+	return c
+}
+
+// mapRequest maps the request and the services derived from it on the context, filling the
+// given empty Headers and QueryParams.
+func (s *yawf) mapRequest(c Context, req *http.Request, headers Headers, query QueryParams) {
 	c.SetParent(s)
 	c.Map(req)
+	c.MapTo(req.Context(), (*gocontext.Context)(nil))
 
-	headers := make(Headers)
 	for key, values := range req.Header {
 		headers[key] = strings.Join(values, ", ")
 	}
 	c.Map(headers)
 
-	req.ParseMultipartForm(1024 * 4)
-	query, _ := url.ParseQuery(req.URL.RawQuery)
-	queryParams := QueryParams(query)
-	c.Map(queryParams)
+	parseQuery(url.Values(query), req.URL.RawQuery)
+	c.Map(query)
 
-	formParams := FormParams(req.PostForm)
-	c.Map(formParams)
+	id := requestID(req)
+	c.Map(id)
+	res := c.Get(inject.InterfaceOf((*http.ResponseWriter)(nil))).Interface().(http.ResponseWriter)
+	res.Header().Set("X-Request-ID", string(id))
 
-	return c
+	// FormParams are parsed lazily, see parseForm
 }
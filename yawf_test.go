@@ -0,0 +1,237 @@
+package yawf
+
+import (
+	"bytes"
+	gocontext "context"
+	"github.com/codegangsta/inject"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server with the Recovery middleware that doesn't log.
+func newTestServer() YawfServer {
+	s := New()
+	s.SetLogger(log.New(io.Discard, "", 0))
+	s.Use(Recovery())
+	return s
+}
+
+// serve runs a request without a body through the server.
+func serve(s YawfServer, method string, target string) *httptest.ResponseRecorder {
+	return s.ServeTest(httptest.NewRequest(method, target, nil))
+}
+
+// runTestServer runs the server on the addresses until it serves, returning the channel Run's
+// error is sent on once it returns.
+func runTestServer(t *testing.T, s YawfServer, addresses ...string) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- s.RunOnAddresses(addresses...) }()
+	for i := 0; s.(*classicYawf).server.Load() == nil; i++ {
+		if i == 1000 {
+			t.Fatal("the server didn't start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return done
+}
+
+// waitRun waits for Run to return and returns its error.
+func waitRun(t *testing.T, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return")
+		return nil
+	}
+}
+
+func TestConcurrentShutdown(t *testing.T) {
+	s := newTestServer()
+	var calls int32
+	s.OnShutdown(func() { atomic.AddInt32(&calls, 1) })
+	s.Get("/", func() string { return "up" })
+
+	done := runTestServer(t, s, "127.0.0.1:0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Shutdown(gocontext.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			s.Stop()
+		}()
+	}
+	wg.Wait()
+
+	if err := waitRun(t, done); err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("the shutdown functions ran %d times, want once", calls)
+	}
+}
+
+// greeter is an interface services are mapped to by MapGlobalTo.
+type greeter interface{ Greet() string }
+
+type english struct{}
+
+func (english) Greet() string { return "hello" }
+
+type appConfig struct{ name string }
+
+func TestMapGlobal(t *testing.T) {
+	parent := inject.New()
+	parent.Map(time.Duration(42))
+
+	s := newTestServer()
+	s.MapGlobal(&appConfig{"global"})
+	s.MapGlobalTo(english{}, (*greeter)(nil))
+	s.SetParentInjector(parent)
+	s.Use(func(c Context, req *http.Request) {
+		if req.URL.Query().Get("override") != "" {
+			c.Map(&appConfig{"request"})
+		}
+	})
+	s.Get("/", func(cfg *appConfig, g greeter, d time.Duration) string {
+		return cfg.name + " " + g.Greet() + " " + d.String()
+	})
+
+	tests := map[string]string{
+		"/":            "global hello 42ns",
+		"/?override=1": "request hello 42ns",
+		"/?override=":  "global hello 42ns",
+	}
+	for target, want := range tests {
+		if body := serve(s, "GET", target).Body.String(); body != want {
+			t.Errorf("%s: got %q, want %q", target, body, want)
+		}
+	}
+}
+
+func TestRunOnAddresses(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func() string { return "up" })
+	done := runTestServer(t, s, "127.0.0.1:0", "127.0.0.1:0")
+
+	listeners := s.(*classicYawf).listeners
+	if len(listeners) != 2 || s.Listener() != listeners[0] {
+		t.Fatalf("got %d listeners", len(listeners))
+	}
+	for _, listener := range listeners {
+		res, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "up" {
+			t.Errorf("%s: got %q", listener.Addr(), body)
+		}
+	}
+
+	s.Stop()
+	if err := waitRun(t, done); err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+	for _, listener := range listeners {
+		if _, err := http.Get("http://" + listener.Addr().String() + "/"); err == nil {
+			t.Errorf("%s still serves after Stop", listener.Addr())
+		}
+	}
+}
+
+func TestRunOnAddressesErrors(t *testing.T) {
+	if err := newTestServer().RunOnAddresses(); err == nil {
+		t.Error("no error without addresses")
+	}
+
+	// the listeners opened before the one failing are closed
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := free.Addr().String()
+	free.Close()
+	if err := newTestServer().RunOnAddresses(address, taken.Addr().String()); err == nil {
+		t.Fatal("no error for an address in use")
+	}
+	reopened, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("the first listener wasn't closed: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestOnShutdown(t *testing.T) {
+	var logs bytes.Buffer
+	s := newTestServer()
+	s.SetLogger(log.New(&logs, "", 0))
+
+	var mu sync.Mutex
+	var events []string
+	event := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, name)
+	}
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.Get("/slow", func() string {
+		close(entered)
+		<-release
+		event("request")
+		return "done"
+	})
+	s.OnShutdown(func() { event("first") })
+	s.OnShutdown(func() { panic("failed") })
+	s.OnShutdown(func() { event("third") })
+	done := runTestServer(t, s, "127.0.0.1:0")
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + s.Listener().Addr().String() + "/slow")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		responses <- string(body)
+	}()
+	<-entered
+	go s.Stop()
+	// the shutdown functions wait for the request in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := waitRun(t, done); err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+	if body := <-responses; body != "done" {
+		t.Errorf("the request in flight got %q", body)
+	}
+	if got := strings.Join(events, ", "); got != "request, first, third" {
+		t.Errorf("got events %s", got)
+	}
+	if !strings.Contains(logs.String(), "PANIC in shutdown function: failed") {
+		t.Errorf("the panic wasn't logged: %q", logs.String())
+	}
+}